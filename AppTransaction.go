@@ -0,0 +1,160 @@
+package AppleTransactions
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// AppMetadata - app level receipt data, same for receipts and StoreKit 2 AppTransaction.
+type AppMetadata struct {
+	// Environment - "Production", "Sandbox" or "ProductionSandbox" for offline parsed receipts.
+	Environment                string
+	BundleID                   string
+	AppAppleID                 int64
+	ApplicationVersion         string
+	OriginalApplicationVersion string
+	VersionExternalIdentifier  int64
+
+	// OriginalPurchaseDate - Unix timestamp of first app download.
+	OriginalPurchaseDate int64
+	// CreatedAt - Unix timestamp, when receipt or AppTransaction was issued.
+	CreatedAt int64
+}
+
+// AppTransaction - verified StoreKit 2 AppTransaction.
+type AppTransaction struct {
+	AppMetadata
+
+	DeviceVerification      string
+	DeviceVerificationNonce string
+
+	// PreorderDate - Unix timestamp, 0 if app wasn't preordered.
+	PreorderDate int64
+}
+
+// signedAppTransaction - AppTransaction JWS payload.
+type signedAppTransaction struct {
+	ReceiptType                string `json:"receiptType"`
+	Environment                string `json:"environment"`
+	AppAppleID                 int64  `json:"appAppleId"`
+	BundleID                   string `json:"bundleId"`
+	ApplicationVersion         string `json:"applicationVersion"`
+	VersionExternalIdentifier  int64  `json:"versionExternalIdentifier"`
+	ReceiptCreationDate        int64  `json:"receiptCreationDate"`
+	OriginalPurchaseDate       int64  `json:"originalPurchaseDate"`
+	OriginalApplicationVersion string `json:"originalApplicationVersion"`
+	DeviceVerification         string `json:"deviceVerification"`
+	DeviceVerificationNonce    string `json:"deviceVerificationNonce"`
+	PreorderDate               int64  `json:"preorderDate"`
+}
+
+// AppTransaction - verifies and decodes AppTransaction.jwsRepresentation sent by StoreKit 2 client.
+//
+// AppTransaction of apps other than BundleID and AppAppleID, or of environment other than Environment, is rejected.
+func (v *JWSVerifier) AppTransaction(signed string) (res *AppTransaction, err error) {
+	var payload signedAppTransaction
+
+	if err = v.Verify(signed, &payload); err != nil {
+		return nil, errors.Wrap(err, "verify AppTransaction")
+	}
+
+	var env = payload.Environment
+	if env == "" {
+		env = payload.ReceiptType
+	}
+
+	if err = v.checkApp(payload.BundleID, env); err != nil {
+		return nil, err
+	}
+
+	if v.AppAppleID != 0 && payload.AppAppleID != v.AppAppleID && (payload.AppAppleID != 0 || env == "Production") {
+		return nil, errors.Errorf("unexpected appAppleId %d", payload.AppAppleID)
+	}
+
+	res = &AppTransaction{
+		AppMetadata: AppMetadata{
+			Environment:                env,
+			BundleID:                   payload.BundleID,
+			AppAppleID:                 payload.AppAppleID,
+			ApplicationVersion:         payload.ApplicationVersion,
+			OriginalApplicationVersion: payload.OriginalApplicationVersion,
+			VersionExternalIdentifier:  payload.VersionExternalIdentifier,
+			OriginalPurchaseDate:       msToUnix(payload.OriginalPurchaseDate),
+			CreatedAt:                  msToUnix(payload.ReceiptCreationDate),
+		},
+		DeviceVerification:      payload.DeviceVerification,
+		DeviceVerificationNonce: payload.DeviceVerificationNonce,
+	}
+
+	if payload.PreorderDate != 0 {
+		res.PreorderDate = msToUnix(payload.PreorderDate)
+	}
+
+	return res, nil
+}
+
+// VerifyDevice - checks deviceVerification against identifierForVendor of the device.
+//
+// Apple hashes lowercased nonce followed by lowercased device identifier with SHA-384.
+func (a *AppTransaction) VerifyDevice(deviceID string) error {
+	if _, err := parseUUID(deviceID); err != nil {
+		return err
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(a.DeviceVerification)
+	if err != nil || len(expected) == 0 {
+		return errors.New("malformed deviceVerification")
+	}
+
+	digest := sha512.Sum384([]byte(strings.ToLower(a.DeviceVerificationNonce) + strings.ToLower(deviceID)))
+
+	if subtle.ConstantTimeCompare(digest[:], expected) != 1 {
+		return errors.New("device verification mismatch")
+	}
+
+	return nil
+}
+
+// metadata - app level data of verifyReceipt response.
+func (r *receiptData) metadata() AppMetadata {
+	var meta = r.Receipt.metadata()
+
+	if r.Environment != "" {
+		meta.Environment = r.Environment
+	}
+
+	return meta
+}
+
+// Metadata - app level data of offline parsed receipt.
+func (p *ParsedReceipt) Metadata() AppMetadata {
+	var meta = p.receipt.metadata()
+	meta.CreatedAt = p.CreatedAt
+
+	return meta
+}
+
+func (r *receipt) metadata() AppMetadata {
+	var meta = AppMetadata{
+		Environment:                r.ReceiptType,
+		BundleID:                   r.BundleID,
+		AppAppleID:                 int64(r.AdamID),
+		ApplicationVersion:         r.ApplicationVersion,
+		OriginalApplicationVersion: r.OriginalApplicationVersion,
+		VersionExternalIdentifier:  int64(r.VersionExternalIdentifier),
+	}
+
+	if ms, err := strconv.ParseInt(r.OriginalPurchaseDateMS, 10, 64); err == nil {
+		meta.OriginalPurchaseDate = msToUnix(ms)
+	}
+
+	if ms, err := strconv.ParseInt(r.ReceiptCreationDateMS, 10, 64); err == nil {
+		meta.CreatedAt = msToUnix(ms)
+	}
+
+	return meta
+}
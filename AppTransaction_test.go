@@ -0,0 +1,65 @@
+package AppleTransactions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppTransactionApp(t *testing.T) {
+	v, s := newTestJWS(t)
+
+	v.BundleID, v.AppAppleID = "com.example.app", 1234567890
+
+	var app = signedAppTransaction{
+		ReceiptType:         "Production",
+		Environment:         "Production",
+		AppAppleID:          1234567890,
+		BundleID:            "com.example.app",
+		ApplicationVersion:  "42",
+		ReceiptCreationDate: time.Now().UnixMilli(),
+	}
+
+	res, err := v.AppTransaction(s.sign(t, app))
+	if err != nil {
+		t.Fatalf("AppTransaction: %v", err)
+	}
+
+	if res.BundleID != app.BundleID || res.AppAppleID != app.AppAppleID || res.Environment != "Production" {
+		t.Errorf("unexpected metadata %+v", res.AppMetadata)
+	}
+
+	var sandbox = app
+	sandbox.Environment, sandbox.ReceiptType, sandbox.AppAppleID = "Sandbox", "Sandbox", 0
+
+	if _, err = v.AppTransaction(s.sign(t, sandbox)); err != nil {
+		t.Errorf("sandbox without appAppleId: %v", err)
+	}
+
+	var (
+		otherBundle = app
+		otherApp    = app
+		missingApp  = app
+	)
+
+	otherBundle.BundleID = "com.example.other"
+	otherApp.AppAppleID = 987654321
+	missingApp.AppAppleID = 0
+
+	for name, c := range map[string]struct {
+		app  signedAppTransaction
+		env  string
+		want string
+	}{
+		"other bundle":              {otherBundle, "", "unexpected bundleId"},
+		"other app id":              {otherApp, "", "unexpected appAppleId"},
+		"production without app id": {missingApp, "", "unexpected appAppleId"},
+		"sandbox in production":     {sandbox, "Production", "unexpected environment"},
+	} {
+		v.Environment = c.env
+
+		if _, err = v.AppTransaction(s.sign(t, c.app)); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package AppleTransactions
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RevalidationJob - stored receipt to revalidate.
+type RevalidationJob struct {
+	// Seq - position of the job in the run, starts at 0 and grows by one, checkpoints are based on it.
+	Seq int64
+
+	UserID  string
+	Receipt string
+
+	// SharedPassword - BulkOptions.SharedPassword if empty.
+	SharedPassword string
+}
+
+// RevalidationResult - outcome of a RevalidationJob.
+type RevalidationResult struct {
+	Job      RevalidationJob
+	Result   ReceiptResult
+	Err      error
+	Attempts int
+}
+
+// Checkpointer - persists progress of a bulk run, so a crashed run resumes where it stopped.
+type Checkpointer interface {
+	// Load - Seq of last job which, with all jobs before it, was delivered, -1 for a new run.
+	Load(ctx context.Context) (int64, error)
+
+	// Save - records seq as delivered.
+	Save(ctx context.Context, seq int64) error
+}
+
+// BulkOptions - settings of Client.Revalidate.
+type BulkOptions struct {
+	// Workers - concurrent validations, 1 if 0. Client.RateLimits still apply.
+	Workers int
+
+	// MaxAttempts - attempts per job for temporary failures, 3 if 0.
+	MaxAttempts int
+
+	// Backoff - delay before second attempt, doubled for every next one, 1 second if 0.
+	Backoff time.Duration
+
+	// Checkpoint - may be nil, then every job is processed.
+	Checkpoint Checkpointer
+
+	// SharedPassword - secret of jobs without their own.
+	SharedPassword string
+}
+
+// Revalidate - validates jobs with bounded concurrency and streams results back in completion order.
+//
+// Result channel is closed after jobs is closed and drained, or ctx is done, even if jobs stays open.
+// Jobs already delivered according to Checkpoint are skipped,
+// a job is checkpointed once its result and results of all jobs before it are received.
+func (c *Client) Revalidate(ctx context.Context, jobs <-chan RevalidationJob, opts BulkOptions) (<-chan RevalidationResult, error) {
+	var last int64 = -1
+
+	if opts.Checkpoint != nil {
+		var err error
+
+		if last, err = opts.Checkpoint.Load(ctx); err != nil {
+			return nil, errors.Wrap(err, "checkpoint Load")
+		}
+	}
+
+	var (
+		workers = opts.Workers
+		done    = make(chan RevalidationResult)
+		out     = make(chan RevalidationResult)
+		wg      sync.WaitGroup
+	)
+
+	if workers <= 0 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				var (
+					job RevalidationJob
+					ok  bool
+				)
+
+				// producer may never close jobs after ctx is done
+				select {
+				case job, ok = <-jobs:
+				case <-ctx.Done():
+					return
+				}
+
+				if !ok {
+					return
+				}
+
+				if job.Seq <= last {
+					continue
+				}
+
+				var res = c.revalidate(ctx, job, opts)
+
+				select {
+				case done <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	go func() {
+		defer close(out)
+
+		var (
+			watermark = last
+			completed = make(map[int64]struct{})
+		)
+
+		for res := range done {
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+
+			if opts.Checkpoint == nil {
+				continue
+			}
+
+			completed[res.Job.Seq] = struct{}{}
+
+			var advanced bool
+
+			for {
+				if _, ok := completed[watermark+1]; !ok {
+					break
+				}
+
+				delete(completed, watermark+1)
+				watermark++
+				advanced = true
+			}
+
+			if advanced {
+				// failed save only delays resume point, run goes on
+				_ = opts.Checkpoint.Save(ctx, watermark)
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// revalidate - one job with retries of temporary failures.
+func (c *Client) revalidate(ctx context.Context, job RevalidationJob, opts BulkOptions) (res RevalidationResult) {
+	var (
+		attempts = opts.MaxAttempts
+		backoff  = opts.Backoff
+		secret   = job.SharedPassword
+	)
+
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	if secret == "" {
+		secret = opts.SharedPassword
+	}
+
+	res.Job = job
+
+	for res.Attempts < attempts {
+		res.Attempts++
+
+		if res.Result, res.Err = c.ValidateReceipt(ctx, job.Receipt, secret, ForUser(job.UserID)); res.Err == nil || !temporary(res.Err) {
+			return res
+		}
+
+		if res.Attempts == attempts {
+			break
+		}
+
+		var (
+			wait    = backoff
+			httpErr *HTTPError
+		)
+
+		// Apple asked to come back later
+		if errors.As(res.Err, &httpErr) && httpErr.RetryAfter > wait {
+			wait = httpErr.RetryAfter
+		}
+
+		var timer = time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return res
+		}
+
+		backoff *= 2
+	}
+
+	return res
+}
+
+// temporary - err may go away when request is repeated: network failures, rate limits, 5xx responses and statuses
+// Apple asks to retry. Malformed responses and Store failures aren't, repeating the request doesn't fix them.
+func temporary(err error) bool {
+	var stored storeError
+	if errors.As(err, &stored) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		return true
+	}
+
+	var status StatusError
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Temporary()
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
+// FileCheckpoint - Checkpointer keeping seq in a file, replaced atomically on save.
+type FileCheckpoint struct {
+	Path string
+}
+
+func (f FileCheckpoint) Load(_ context.Context) (int64, error) {
+	raw, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+
+	if err != nil {
+		return -1, errors.Wrap(err, "failed ReadFile")
+	}
+
+	seq, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return -1, errors.Wrap(err, "failed ParseInt")
+	}
+
+	return seq, nil
+}
+
+func (f FileCheckpoint) Save(_ context.Context, seq int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed CreateTemp")
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.WriteString(strconv.FormatInt(seq, 10)); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed WriteString")
+	}
+
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed Close")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), f.Path), "failed Rename")
+}
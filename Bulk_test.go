@@ -0,0 +1,199 @@
+package AppleTransactions
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// roundTripFunc - stub of Apple endpoints.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func jsonResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// failingStore - Store which can't write transactions.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) UpsertTransactions(context.Context, string, []Transaction) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+func TestRevalidateAttempts(t *testing.T) {
+	for name, c := range map[string]struct {
+		respond func() (*http.Response, error)
+		store   Store
+		want    int
+	}{
+		"network failure": {func() (*http.Response, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		}, nil, 3},
+		"temporary status": {func() (*http.Response, error) { return jsonResponse(200, `{"status":21005}`), nil }, nil, 3},
+		"permanent status": {func() (*http.Response, error) { return jsonResponse(200, `{"status":21010}`), nil }, nil, 1},
+		"unavailable": {func() (*http.Response, error) {
+			return jsonResponse(503, `<html>Service Unavailable</html>`), nil
+		}, nil, 3},
+		"not found": {func() (*http.Response, error) { return jsonResponse(404, `<html>Not Found</html>`), nil }, nil, 1},
+		"malformed response": {func() (*http.Response, error) {
+			return jsonResponse(200, `<html>OK</html>`), nil
+		}, nil, 1},
+		"store failure": {func() (*http.Response, error) {
+			return jsonResponse(200, `{"status":0}`), nil
+		}, failingStore{NewMemoryStore()}, 1},
+	} {
+		var client = &Client{
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) { return c.respond() })},
+			Store:      c.store,
+		}
+
+		var res = client.revalidate(context.Background(), RevalidationJob{UserID: "user", Receipt: "receipt"},
+			BulkOptions{Backoff: time.Millisecond})
+
+		if res.Err == nil || res.Attempts != c.want {
+			t.Errorf("%s: %d attempts, %v, want %d", name, res.Attempts, res.Err, c.want)
+		}
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	var syntax error
+	if err := json.Unmarshal([]byte("<html>"), &struct{}{}); err != nil {
+		syntax = errors.Wrap(err, "failed Decode response")
+	}
+
+	for err, want := range map[error]bool{
+		errors.Wrap(&net.OpError{Op: "read", Err: errors.New("reset")}, "failed http.Post"): true,
+		&RateLimitError{Endpoint: EndpointVerifyReceipt, RetryAfter: time.Second}:           true,
+		errors.WithStack(StatusError(21009)):                                                true,
+		errors.WithStack(StatusError(21010)):                                                false,
+		&HTTPError{StatusCode: 503}:                                                         true,
+		&HTTPError{StatusCode: 400}:                                                         false,
+		syntax:                                                                              false,
+		storeError{errors.Wrap(&net.OpError{Op: "dial"}, "store UpsertTransactions")}:       false,
+		errors.Wrap(context.Canceled, "failed http.Post"):                                   false,
+		errors.New("unexpected"):                                                            false,
+	} {
+		if got := temporary(err); got != want {
+			t.Errorf("temporary(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestRevalidateCancelWithOpenJobs(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		jobs        = make(chan RevalidationJob)
+		requests    int32
+		client      = &Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			return jsonResponse(200, `{"status":0}`), nil
+		})}}
+	)
+
+	out, err := client.Revalidate(ctx, jobs, BulkOptions{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs <- RevalidationJob{Seq: 0, Receipt: "receipt"}
+
+	if res := <-out; res.Err != nil {
+		t.Fatalf("job: %v", res.Err)
+	}
+
+	// producer stops feeding jobs without closing the channel
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatal("result after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("results not closed after cancel")
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestQueryRetryAfter(t *testing.T) {
+	var client = &Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		var resp = jsonResponse(503, `<html>Service Unavailable</html>`)
+		resp.Header.Set("Retry-After", "7")
+		return resp, nil
+	})}}
+
+	_, err := client.ValidateReceipt(context.Background(), "receipt", "")
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 503 || httpErr.RetryAfter != 7*time.Second {
+		t.Fatalf("%v, want HTTPError 503 retry after 7s", err)
+	}
+
+	// next request holds back instead of hitting Apple again
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var limited *RateLimitError
+	if _, err = client.ValidateReceipt(ctx, "other receipt", ""); !errors.As(err, &limited) {
+		t.Errorf("%v, want RateLimitError", err)
+	}
+}
+
+func TestRevalidateStoresForUser(t *testing.T) {
+	const body = `{"status":0,"latest_receipt":"latest","latest_receipt_info":[{"product_id":"monthly",
+		"transaction_id":"1","original_transaction_id":"1","expires_date_ms":"4102444800000"}]}`
+
+	var (
+		ctx    = context.Background()
+		store  = NewMemoryStore()
+		client = &Client{
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+				return jsonResponse(200, body), nil
+			})},
+			Store: store,
+		}
+		scheduler = &Scheduler{Client: client, Store: NewMemoryScheduleStore()}
+	)
+
+	if res := client.revalidate(ctx, RevalidationJob{UserID: "alice", Receipt: "receipt"}, BulkOptions{}); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+
+	if transactions, err := store.TransactionsByUser(ctx, "alice"); err != nil || len(transactions) != 1 {
+		t.Errorf("alice: %d transactions, %v", len(transactions), err)
+	}
+
+	if err := scheduler.check(ctx, ScheduledCheck{OriginalID: "1", UserID: "bob", Receipt: "receipt"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		receipts, err := store.Receipts(ctx, user)
+		if err != nil || len(receipts) != 1 || receipts[0].Receipt != "latest" {
+			t.Errorf("%s: receipts %v, %v", user, receipts, err)
+		}
+	}
+
+}
@@ -0,0 +1,164 @@
+package AppleTransactions
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL - Client.CacheTTL if it's not set.
+const DefaultCacheTTL = time.Hour
+
+// Cache - storage for verifyReceipt responses, shaped after Redis GET and SET EX.
+//
+// Cache failures are treated as misses, Apple is asked instead.
+type Cache interface {
+	// Get - value of key, false if it's missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set - stores value of key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// cacheKey - hash of receipt data, shared secret and environment.
+func (q *appleQuery) cacheKey(sandbox bool) string {
+	var h = sha256.New()
+	h.Write([]byte(strconv.FormatBool(sandbox)))
+	h.Write([]byte{0})
+	h.Write([]byte(q.Password))
+	h.Write([]byte{0})
+	h.Write([]byte(q.ReceiptData))
+
+	return "appletransactions:" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Client) cached(ctx context.Context, key string) (res receiptData, ok bool) {
+	raw, ok, err := c.Cache.Get(ctx, key)
+	if err != nil || !ok {
+		return res, false
+	}
+
+	if err = json.Unmarshal(raw, &res); err != nil {
+		return res, false
+	}
+
+	return res, true
+}
+
+// store - caches responses which don't change until a subscription renews.
+//
+// Status 21007 is cached as well, so sandbox receipts skip production next time.
+func (c *Client) store(ctx context.Context, key string, res receiptData) {
+	if res.Status != 0 && res.Status != 21007 {
+		return
+	}
+
+	var ttl = c.cacheTTL(res, time.Now())
+	if ttl <= 0 {
+		return
+	}
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+
+	_ = c.Cache.Set(ctx, key, raw, ttl)
+}
+
+// cacheTTL - until nearest subscription expiry, when Apple may renew it, capped by CacheTTL.
+func (c *Client) cacheTTL(res receiptData, now time.Time) time.Duration {
+	var ttl = c.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	for _, v := range res.LatestReceiptInfo {
+		expires, err := unixFromMS(v.ExpiresDateMS)
+		if err != nil || expires == 0 || expires <= now.Unix() {
+			continue
+		}
+
+		if left := time.Unix(expires, 0).Sub(now); left < ttl {
+			ttl = left
+		}
+	}
+
+	return ttl
+}
+
+// LRUCache - in-memory Cache which evicts least recently used entries.
+type LRUCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache - LRUCache of at most size entries.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var e = el.Value.(*lruEntry)
+
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(el)
+
+	return e.value, true, nil
+}
+
+func (c *LRUCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e = &lruEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	return nil
+}
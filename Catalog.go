@@ -0,0 +1,124 @@
+package AppleTransactions
+
+import (
+	"sort"
+	"time"
+)
+
+// ProductType - in-app product type, values match StoreKit 2 transaction type.
+type ProductType string
+
+const (
+	ProductConsumable    ProductType = "Consumable"
+	ProductNonConsumable ProductType = "Non-Consumable"
+	ProductAutoRenewable ProductType = "Auto-Renewable Subscription"
+	ProductNonRenewing   ProductType = "Non-Renewing Subscription"
+)
+
+// Product - catalog entry of an in-app product.
+type Product struct {
+	Type ProductType
+
+	// Duration - access period of non-renewing subscription, Apple doesn't track it.
+	Duration time.Duration
+}
+
+// Catalog - product ID to product, receipts don't tell consumables,
+// non-consumables and non-renewing subscriptions apart.
+type Catalog map[string]Product
+
+// Entitlement - access user has to a product.
+type Entitlement struct {
+	ProductID     string
+	Type          ProductType
+	TransactionID string
+
+	// Active - user has access now, consumables never grant lasting access, credit them with Ledger.
+	Active bool
+
+	// ExpireAt - Unix timestamp, 0 if access doesn't expire.
+	ExpireAt int64
+}
+
+// Classify - product type of t, empty if neither catalog nor transaction tells it.
+func (c Catalog) Classify(t Transaction) ProductType {
+	if p, ok := c[t.InAppName]; ok {
+		return p.Type
+	}
+
+	if t.ProductType != "" {
+		return t.ProductType
+	}
+
+	if t.SubscriptionExpireAt != 0 {
+		return ProductAutoRenewable
+	}
+
+	return ""
+}
+
+// Entitlement - access t alone gives at now.
+func (c Catalog) Entitlement(t Transaction, now time.Time) Entitlement {
+	var res = Entitlement{
+		ProductID:     t.InAppName,
+		Type:          c.Classify(t),
+		TransactionID: t.ID,
+	}
+
+	switch res.Type {
+	case ProductNonConsumable:
+		res.Active = true
+	case ProductAutoRenewable:
+		res.ExpireAt = t.AccessExpireAt
+	case ProductNonRenewing:
+		res.ExpireAt = time.Unix(t.PurchasedAt, 0).Add(c[t.InAppName].Duration).Unix()
+	}
+
+	if res.ExpireAt != 0 {
+		res.Active = now.Unix() < res.ExpireAt
+	}
+
+	if t.RevokedAt != 0 {
+		res.Active = false
+		if res.ExpireAt == 0 || t.RevokedAt < res.ExpireAt {
+			res.ExpireAt = t.RevokedAt
+		}
+	}
+
+	return res
+}
+
+// Entitlements - access per product ID at now.
+//
+// Non-renewing subscription purchases stack: each one extends access from the end of previous one.
+func (c Catalog) Entitlements(history []Transaction, now time.Time) map[string]Entitlement {
+	var sorted = append([]Transaction{}, history...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PurchasedAt < sorted[j].PurchasedAt })
+
+	var res = make(map[string]Entitlement)
+
+	for _, t := range sorted {
+		if t.RevokedAt != 0 {
+			if _, ok := res[t.InAppName]; !ok {
+				res[t.InAppName] = c.Entitlement(t, now)
+			}
+			continue
+		}
+
+		var (
+			e        = c.Entitlement(t, now)
+			prev, ok = res[t.InAppName]
+		)
+
+		if ok && e.Type == ProductNonRenewing && prev.ExpireAt > t.PurchasedAt {
+			e.ExpireAt = time.Unix(prev.ExpireAt, 0).Add(c[t.InAppName].Duration).Unix()
+			e.Active = now.Unix() < e.ExpireAt
+		}
+
+		if !ok || e.Active || !prev.Active && e.ExpireAt >= prev.ExpireAt {
+			res[t.InAppName] = e
+		}
+	}
+
+	return res
+}
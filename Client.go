@@ -0,0 +1,191 @@
+package AppleTransactions
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client - verifyReceipt client, zero value is ready to use.
+//
+// Concurrent calls with the same receipt and secret share one request to Apple.
+// Client must not be copied after first use.
+type Client struct {
+	// HTTPClient - http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Cache - when set, verifyReceipt responses are cached, see Cache.
+	Cache Cache
+
+	// CacheTTL - longest time a response stays cached, DefaultCacheTTL if 0.
+	CacheTTL time.Duration
+
+	// RateLimits - client side limits per endpoint family, unlimited if missing.
+	RateLimits map[EndpointFamily]RateLimit
+
+	// Secrets - resolves shared secret by bundle id when caller passes none, see SecretProvider.
+	Secrets SecretProvider
+
+	// Logger - may be nil, gets which secret version validated a receipt, never secrets themselves.
+	Logger Logger
+
+	// Store - when set, validated receipts are written to it, see ForUser.
+	Store Store
+
+	flights flightGroup
+
+	limitersMu sync.Mutex
+	limiters   map[EndpointFamily]*limiter
+}
+
+// defaultClient - client of package level functions.
+var defaultClient = &Client{}
+
+// TransactionsByReceipt - retrieve all transactions by apple receipt.
+//
+// Apple status != 0 will return in error as string.
+func (c *Client) TransactionsByReceipt(ctx context.Context, receipt, sharedPassword string, opts ...ReceiptOption) (res []Transaction, err error) {
+	result, err := c.ValidateReceipt(ctx, receipt, sharedPassword, opts...)
+	if err != nil {
+		return res, err
+	}
+
+	return result.Transactions, nil
+}
+
+// ValidateReceipt - like TransactionsByReceipt, but with receipt metadata.
+//
+// Empty sharedPassword is resolved through Client.Secrets when it's set.
+func (c *Client) ValidateReceipt(ctx context.Context, receipt, sharedPassword string, opts ...ReceiptOption) (res ReceiptResult, err error) {
+	var resp receiptData
+
+	if sharedPassword == "" && c.Secrets != nil {
+		resp, err = c.verifyWithSecrets(ctx, receipt)
+	} else {
+		resp, err = c.verify(ctx, receipt, sharedPassword)
+	}
+
+	if err != nil {
+		return res, err
+	}
+
+	if resp.Status != 0 {
+		return res, errors.WithStack(StatusError(resp.Status))
+	}
+
+	if res.Transactions, err = resp.collectTransactions(); err != nil {
+		return res, err
+	}
+
+	res.AppMetadata = resp.metadata()
+	res.LatestReceipt = resp.LatestReceipt
+
+	for _, v := range resp.PendingRenewalInfo {
+		info, err := v.renewalInfo()
+		if err != nil {
+			return res, errors.Wrap(err, "renewalInfo fail")
+		}
+
+		res.RenewalInfo = append(res.RenewalInfo, info)
+	}
+
+	applyGracePeriod(res.Transactions, res.RenewalInfo)
+
+	var o = newReceiptOptions(opts)
+
+	if c.Store != nil {
+		if err = c.write(ctx, o.userID, receipt, res); err != nil {
+			return res, storeError{err}
+		}
+	}
+
+	res.Transactions = o.filter(res.Transactions)
+
+	return res, nil
+}
+
+// verify - verifyReceipt in production, then in sandbox for sandbox receipts.
+func (c *Client) verify(ctx context.Context, receipt, sharedPassword string) (resp receiptData, err error) {
+	var req = appleQuery{
+		ReceiptData: receipt,
+		Password:    sharedPassword,
+	}
+
+	resp, err = c.query(ctx, &req, false)
+	if err != nil {
+		return resp, errors.Wrap(err, "apple query(sandbox:false)")
+	}
+
+	if resp.Status == 21007 {
+		resp, err = c.query(ctx, &req, true)
+		if err != nil {
+			return resp, errors.Wrap(err, "apple query(sandbox:true)")
+		}
+	}
+
+	return resp, nil
+}
+
+// query - appleQuery through cache, coalesced with identical queries in flight.
+func (c *Client) query(ctx context.Context, q *appleQuery, sandbox bool) (res receiptData, err error) {
+	var key = q.cacheKey(sandbox)
+
+	if c.Cache != nil {
+		if res, ok := c.cached(ctx, key); ok {
+			return res, nil
+		}
+	}
+
+	// rate limit wait is bounded by deadline of the caller starting the request
+	deadline, _ := ctx.Deadline()
+
+	return c.flights.do(ctx, key, func(ctx context.Context) (res receiptData, err error) {
+		if res, err = c.limitedQuery(ctx, q, sandbox, deadline); err != nil {
+			return res, err
+		}
+
+		if c.Cache != nil {
+			c.store(ctx, key, res)
+		}
+
+		return res, nil
+	})
+}
+
+// limitedQuery - appleQuery within rate limits, retried after Retry-After when Apple throttles.
+func (c *Client) limitedQuery(ctx context.Context, q *appleQuery, sandbox bool, deadline time.Time) (res receiptData, err error) {
+	var lim = c.limiter(EndpointVerifyReceipt)
+
+	for attempt := 0; ; attempt++ {
+		release, err := lim.wait(ctx, deadline)
+		if err != nil {
+			return res, err
+		}
+
+		res, err = q.query(ctx, c.httpClient(), sandbox)
+		release()
+
+		// 503 isn't retried here, but other requests hold back as Apple asked
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			lim.block(httpErr.RetryAfter)
+		}
+
+		var limited *RateLimitError
+		if !errors.As(err, &limited) || attempt == maxRateLimitRetries {
+			return res, err
+		}
+
+		lim.block(limited.RetryAfter)
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return http.DefaultClient
+}
@@ -0,0 +1,79 @@
+package AppleTransactions
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup - coalesces concurrent identical verifyReceipt queries into one in-flight request.
+//
+// Request runs with its own context, which is cancelled once every waiting caller is gone,
+// so each caller still cancels independently by its own context.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	res receiptData
+	err error
+}
+
+// do - result of fn for key, shared with callers which come while fn is running.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (receiptData, error)) (receiptData, error) {
+	g.mu.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	c, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.Background())
+
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+
+		go func() {
+			c.res, c.err = fn(callCtx)
+
+			g.mu.Lock()
+			g.forget(key, c)
+			g.mu.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.res, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+
+		if c.waiters == 0 {
+			g.forget(key, c)
+			c.cancel()
+		}
+
+		g.mu.Unlock()
+
+		return receiptData{}, ctx.Err()
+	}
+}
+
+// forget - next caller of key starts a new call, g.mu must be held.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,285 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// DedupStore - remembers processed notifications, Apple retries and may deliver duplicates out of order.
+type DedupStore interface {
+	// Claim - records notification uuid as being processed, false if it was completed
+	// or claimed less than lease ago. Unfinished claims older than lease are taken over.
+	Claim(ctx context.Context, uuid string, lease time.Duration) (bool, error)
+
+	// Complete - marks uuid processed, its claim never expires afterwards.
+	Complete(ctx context.Context, uuid string) error
+
+	// Release - forgets uuid, so retry of a failed notification is processed again.
+	Release(ctx context.Context, uuid string) error
+
+	// Advance - moves signed date of key forward, false if signedDateMS is older than recorded one.
+	Advance(ctx context.Context, key string, signedDateMS int64) (bool, error)
+}
+
+// Process - dispatches n once per notificationUUID, skipping notifications older than already applied ones.
+//
+// Notifications are ordered by signedDate per original transaction,
+// so a late DID_RENEW can't overwrite state of a newer EXPIRED.
+// Stale notifications go to Stale callback when it's set. Only subscription state
+// is suppressed, refunds, revocations and other events are dispatched however late they are.
+//
+// Notification is completed once its callback succeeds. If the process dies mid callback,
+// retry of the notification is processed after ClaimLease.
+func (h *NotificationHandler) Process(ctx context.Context, n *Notification) error {
+	if h.Dedup == nil {
+		return h.apply(ctx, n)
+	}
+
+	if n.UUID == "" {
+		return h.dispatch(ctx, n)
+	}
+
+	claimed, err := h.Dedup.Claim(ctx, n.UUID, h.claimLease())
+	if err != nil {
+		return errors.Wrap(err, "dedup Claim")
+	}
+
+	if !claimed {
+		return nil
+	}
+
+	if err = h.dispatch(ctx, n); err != nil {
+		_ = h.Dedup.Release(ctx, n.UUID)
+		return err
+	}
+
+	return errors.Wrap(h.Dedup.Complete(ctx, n.UUID), "dedup Complete")
+}
+
+// dispatch - n to callback, or to Stale if it's state older than a notification of its subscription applied before.
+func (h *NotificationHandler) dispatch(ctx context.Context, n *Notification) error {
+	if key := n.orderKey(); key != "" && n.SignedDateMS != 0 {
+		// events advance the order too, so state older than a refund doesn't overwrite it
+		fresh, err := h.Dedup.Advance(ctx, key, n.SignedDateMS)
+		if err != nil {
+			return errors.Wrap(err, "dedup Advance")
+		}
+
+		if !fresh && stateNotifications[n.Type] {
+			if h.Store != nil {
+				if err = h.write(ctx, n, true); err != nil {
+					return err
+				}
+			}
+
+			if h.Stale == nil {
+				return nil
+			}
+
+			return h.Stale(ctx, n)
+		}
+	}
+
+	return h.apply(ctx, n)
+}
+
+func (h *NotificationHandler) claimLease() time.Duration {
+	if h.ClaimLease > 0 {
+		return h.ClaimLease
+	}
+
+	return 10 * time.Minute
+}
+
+// stateNotifications - types carrying subscription state, which a newer notification supersedes.
+var stateNotifications = map[NotificationType]bool{
+	NotificationSubscribed:             true,
+	NotificationDidRenew:               true,
+	NotificationDidFailToRenew:         true,
+	NotificationDidChangeRenewalPref:   true,
+	NotificationDidChangeRenewalStatus: true,
+	NotificationExpired:                true,
+	NotificationGracePeriodExpired:     true,
+	NotificationOfferRedeemed:          true,
+	NotificationPriceIncrease:          true,
+	NotificationRenewalExtended:        true,
+}
+
+// orderKey - notifications of one subscription are ordered against each other.
+func (n *Notification) orderKey() string {
+	if n.Transaction != nil && n.Transaction.OriginalID != "" {
+		return n.Transaction.OriginalID
+	}
+
+	if n.RenewalInfo != nil {
+		return n.RenewalInfo.OriginalTransactionID
+	}
+
+	return ""
+}
+
+// MemoryDedupStore - in-process DedupStore, forgets everything on restart.
+type MemoryDedupStore struct {
+	mu     sync.Mutex
+	claims map[string]dedupClaim
+	signed map[string]int64
+}
+
+type dedupClaim struct {
+	claimedAt time.Time
+	completed bool
+}
+
+// NewMemoryDedupStore - empty MemoryDedupStore.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{
+		claims: make(map[string]dedupClaim),
+		signed: make(map[string]int64),
+	}
+}
+
+func (s *MemoryDedupStore) Claim(_ context.Context, uuid string, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var now = time.Now()
+
+	if c, ok := s.claims[uuid]; ok && (c.completed || now.Before(c.claimedAt.Add(lease))) {
+		return false, nil
+	}
+
+	s.claims[uuid] = dedupClaim{claimedAt: now}
+
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Complete(_ context.Context, uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.claims[uuid] = dedupClaim{claimedAt: s.claims[uuid].claimedAt, completed: true}
+
+	return nil
+}
+
+func (s *MemoryDedupStore) Release(_ context.Context, uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.claims, uuid)
+
+	return nil
+}
+
+func (s *MemoryDedupStore) Advance(_ context.Context, key string, signedDateMS int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if signedDateMS < s.signed[key] {
+		return false, nil
+	}
+
+	s.signed[key] = signedDateMS
+
+	return true, nil
+}
+
+// Forget - drops claims older than d, Apple stops retrying after a few days.
+func (s *MemoryDedupStore) Forget(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deadline = time.Now().Add(-d)
+
+	for uuid, c := range s.claims {
+		if c.claimedAt.Before(deadline) {
+			delete(s.claims, uuid)
+		}
+	}
+}
+
+// SQLDedupStore - DedupStore on database/sql, works with SQLite and Postgres.
+type SQLDedupStore struct {
+	DB *sql.DB
+}
+
+// NewSQLDedupStore - SQLDedupStore on db, call Migrate before use.
+func NewSQLDedupStore(db *sql.DB) *SQLDedupStore {
+	return &SQLDedupStore{DB: db}
+}
+
+// Migrate - creates tables if they don't exist.
+func (s *SQLDedupStore) Migrate(ctx context.Context) error {
+	for _, q := range []string{
+		`CREATE TABLE IF NOT EXISTS apple_notification_claims (
+			uuid TEXT PRIMARY KEY,
+			claimed_at BIGINT NOT NULL,
+			completed_at BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS apple_notification_order (
+			order_key TEXT PRIMARY KEY,
+			signed_date BIGINT NOT NULL
+		)`,
+	} {
+		if _, err := s.DB.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "failed create table")
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLDedupStore) Claim(ctx context.Context, uuid string, lease time.Duration) (bool, error) {
+	var now = time.Now()
+
+	// unfinished claim older than lease is taken over
+	res, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_notification_claims (uuid, claimed_at) VALUES ($1, $2)
+		ON CONFLICT (uuid) DO UPDATE SET claimed_at = excluded.claimed_at
+		WHERE apple_notification_claims.completed_at = 0 AND apple_notification_claims.claimed_at <= $3`,
+		uuid, now.Unix(), now.Add(-lease).Unix())
+	if err != nil {
+		return false, errors.Wrap(err, "failed upsert claim")
+	}
+
+	return affected(res)
+}
+
+func (s *SQLDedupStore) Complete(ctx context.Context, uuid string) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE apple_notification_claims SET completed_at = $1 WHERE uuid = $2`, time.Now().Unix(), uuid)
+
+	return errors.Wrap(err, "failed update claim")
+}
+
+func (s *SQLDedupStore) Release(ctx context.Context, uuid string) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM apple_notification_claims WHERE uuid = $1`, uuid)
+
+	return errors.Wrap(err, "failed delete claim")
+}
+
+func (s *SQLDedupStore) Advance(ctx context.Context, key string, signedDateMS int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_notification_order (order_key, signed_date) VALUES ($1, $2)
+		ON CONFLICT (order_key) DO UPDATE SET signed_date = excluded.signed_date
+		WHERE apple_notification_order.signed_date <= excluded.signed_date`,
+		key, signedDateMS)
+	if err != nil {
+		return false, errors.Wrap(err, "failed upsert order")
+	}
+
+	return affected(res)
+}
+
+// affected - true if statement changed a row.
+func affected(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed RowsAffected")
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,131 @@
+package AppleTransactions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestSQLDedupStore(t *testing.T) *SQLDedupStore {
+	t.Helper()
+
+	var s = NewSQLDedupStore(openSQLite(t))
+
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestProcessDedup(t *testing.T) {
+	for name, store := range map[string]DedupStore{
+		"memory": NewMemoryDedupStore(),
+		"sql":    newTestSQLDedupStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx   = context.Background()
+				calls int
+				fail  error
+				h     = &NotificationHandler{Dedup: store, ClaimLease: time.Hour}
+			)
+
+			h.On(NotificationDidRenew, func(context.Context, *Notification) error {
+				calls++
+				return fail
+			})
+
+			process := func(uuid string, wantCalls int, wantErr bool) {
+				t.Helper()
+
+				var n = &Notification{Type: NotificationDidRenew, UUID: uuid, SignedDateMS: time.Now().UnixMilli()}
+
+				if err := h.Process(ctx, n); (err != nil) != wantErr || calls != wantCalls {
+					t.Fatalf("%s: %d calls, %v, want %d", uuid, calls, err, wantCalls)
+				}
+			}
+
+			process("done", 1, false)
+			process("done", 1, false)
+
+			// failed callback releases its claim, Apple retry is processed
+			fail = errors.New("unavailable")
+			process("failed", 2, true)
+			fail = nil
+			process("failed", 3, false)
+			process("failed", 3, false)
+
+			// process died mid callback, claim stays unfinished
+			if claimed, err := store.Claim(ctx, "crashed", time.Hour); err != nil || !claimed {
+				t.Fatalf("claim: %v, %v", claimed, err)
+			}
+
+			process("crashed", 3, false)
+
+			h.ClaimLease = time.Nanosecond
+			time.Sleep(time.Millisecond)
+
+			process("crashed", 4, false)
+
+			// completed claims never expire
+			process("done", 4, false)
+		})
+	}
+}
+
+func TestProcessLateRefund(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		applied []NotificationType
+		stale   []NotificationType
+		h       = &NotificationHandler{
+			Dedup: NewMemoryDedupStore(),
+			Store: store,
+			Default: func(_ context.Context, n *Notification) error {
+				applied = append(applied, n.Type)
+				return nil
+			},
+			Stale: func(_ context.Context, n *Notification) error {
+				stale = append(stale, n.Type)
+				return nil
+			},
+		}
+	)
+
+	for _, n := range []*Notification{
+		{Type: NotificationDidRenew, UUID: "renew", SignedDateMS: 3000,
+			Transaction: &Transaction{ID: "2", OriginalID: "1", InAppName: "monthly", SubscriptionExpireAt: 3}},
+		// retried REFUND of the previous period arrives after the renewal
+		{Type: NotificationRefund, UUID: "refund", SignedDateMS: 2000,
+			Transaction: &Transaction{ID: "1", OriginalID: "1", InAppName: "monthly", SubscriptionExpireAt: 2, RevokedAt: 2}},
+		{Type: NotificationDidRenew, UUID: "late renew", SignedDateMS: 1000,
+			Transaction: &Transaction{ID: "1", OriginalID: "1", InAppName: "monthly", SubscriptionExpireAt: 2}},
+	} {
+		if err := h.Process(ctx, n); err != nil {
+			t.Fatalf("%s: %v", n.UUID, err)
+		}
+	}
+
+	if len(applied) != 2 || applied[1] != NotificationRefund || len(stale) != 1 || stale[0] != NotificationDidRenew {
+		t.Errorf("applied %v, stale %v", applied, stale)
+	}
+
+	transactions, err := store.TransactionsByOriginalID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tx := range transactions {
+		if tx.ID == "1" && tx.RevokedAt == 0 {
+			t.Errorf("refunded transaction stored without RevokedAt")
+		}
+	}
+
+	if len(transactions) != 2 {
+		t.Errorf("%d transactions stored, want 2", len(transactions))
+	}
+}
@@ -0,0 +1,85 @@
+package AppleTransactions
+
+import (
+	"time"
+)
+
+// Eligibility - subscription offers a user may get within a subscription group.
+type Eligibility struct {
+	SubscriptionGroup string
+
+	// IntroOffer - user never had a free trial or introductory price in the group.
+	IntroOffer bool
+
+	// WinBack - user subscribed before and has been lapsed for at least EligibilityPolicy.WinBackAfter.
+	WinBack bool
+
+	// LapsedFor - time since last subscription in the group expired, 0 if it's active or never existed.
+	LapsedFor time.Duration
+}
+
+// EligibilityPolicy - rules to compute Eligibility from transaction history.
+//
+// History may come from TransactionsByReceipt, ParsedReceipt.Transactions or TransactionsByJWS.
+type EligibilityPolicy struct {
+	// WinBackAfter - how long a subscription must be lapsed for win-back offers.
+	WinBackAfter time.Duration
+
+	// ProductGroups - product ID to subscription group for transactions which don't carry one,
+	// receipt in_app entries and offline parsed receipts.
+	ProductGroups map[string]string
+}
+
+// Check - eligibility of every subscription group present in history.
+func (p EligibilityPolicy) Check(history []Transaction, now time.Time) map[string]Eligibility {
+	var res = make(map[string]Eligibility)
+
+	for _, t := range history {
+		if group := p.group(t); group != "" {
+			if _, ok := res[group]; !ok {
+				res[group] = p.CheckGroup(history, group, now)
+			}
+		}
+	}
+
+	return res
+}
+
+// CheckGroup - eligibility for group, everything but win-back is allowed if user never subscribed to it.
+func (p EligibilityPolicy) CheckGroup(history []Transaction, group string, now time.Time) Eligibility {
+	var (
+		res        = Eligibility{SubscriptionGroup: group, IntroOffer: true}
+		subscribed bool
+		expires    int64
+	)
+
+	for _, t := range history {
+		if p.group(t) != group {
+			continue
+		}
+
+		if t.IsTrialPeriod || t.IsInIntroOfferPeriod {
+			res.IntroOffer = false
+		}
+
+		subscribed = true
+		if t.SubscriptionExpireAt > expires {
+			expires = t.SubscriptionExpireAt
+		}
+	}
+
+	if subscribed && expires != 0 && expires < now.Unix() {
+		res.LapsedFor = now.Sub(time.Unix(expires, 0))
+		res.WinBack = res.LapsedFor >= p.WinBackAfter
+	}
+
+	return res
+}
+
+func (p EligibilityPolicy) group(t Transaction) string {
+	if t.SubscriptionGroup != "" {
+		return t.SubscriptionGroup
+	}
+
+	return p.ProductGroups[t.InAppName]
+}
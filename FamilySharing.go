@@ -0,0 +1,76 @@
+package AppleTransactions
+
+// OwnershipType - how user got access to a purchase.
+type OwnershipType string
+
+const (
+	// OwnershipPurchased - user bought the product.
+	OwnershipPurchased OwnershipType = "PURCHASED"
+	// OwnershipFamilyShared - family member shares the product with user through Family Sharing.
+	OwnershipFamilyShared OwnershipType = "FAMILY_SHARED"
+)
+
+// ReceiptOption - optional behaviour of TransactionsByReceipt and ValidateReceipt.
+type ReceiptOption func(o *receiptOptions)
+
+type receiptOptions struct {
+	ownership []OwnershipType
+	userID    string
+}
+
+// WithOwnershipType - keep only transactions of given ownership types.
+func WithOwnershipType(types ...OwnershipType) ReceiptOption {
+	return func(o *receiptOptions) {
+		o.ownership = append(o.ownership, types...)
+	}
+}
+
+func newReceiptOptions(opts []ReceiptOption) *receiptOptions {
+	var o = &receiptOptions{}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func (o *receiptOptions) filter(transactions []Transaction) (res []Transaction) {
+	if len(o.ownership) == 0 {
+		return transactions
+	}
+
+	for _, t := range transactions {
+		var ownership = t.OwnershipType
+		if ownership == "" {
+			ownership = OwnershipPurchased
+		}
+
+		for _, v := range o.ownership {
+			if v == ownership {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// FamilyAccessRevoked - REVOKE notification, purchaser stopped sharing n.Transaction with the family.
+func (n *Notification) FamilyAccessRevoked() bool {
+	return n.Type == NotificationRevoke && n.Transaction != nil
+}
+
+// revoke - marks transaction revoked at Unix timestamp at, subscription access ends then.
+func (t *Transaction) revoke(at int64) {
+	if at == 0 {
+		return
+	}
+
+	t.RevokedAt = at
+
+	if t.SubscriptionExpireAt != 0 && at < t.AccessExpireAt {
+		t.AccessExpireAt = at
+	}
+}
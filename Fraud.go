@@ -0,0 +1,214 @@
+package AppleTransactions
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// FraudKind - heuristic which raised a FraudFlag.
+type FraudKind string
+
+const (
+	FraudSharedPurchase      FraudKind = "shared_purchase"
+	FraudSandboxInProduction FraudKind = "sandbox_in_production"
+	FraudBundleMismatch      FraudKind = "bundle_mismatch"
+	FraudFutureCreation      FraudKind = "future_creation"
+	FraudForgedStructure     FraudKind = "forged_structure"
+)
+
+// FraudFlag - suspicious property of a receipt.
+type FraudFlag struct {
+	Kind FraudKind
+	// Score - from 0, harmless, to 1, certainly fraud.
+	Score  float64
+	Reason string
+}
+
+// FraudReport - flags of a receipt, empty if nothing is suspicious.
+type FraudReport struct {
+	Flags []FraudFlag
+	// Score - combined score of flags, as if they were independent evidence.
+	Score float64
+}
+
+func (r *FraudReport) flag(kind FraudKind, score float64, reason string, args ...interface{}) {
+	r.Flags = append(r.Flags, FraudFlag{Kind: kind, Score: score, Reason: fmt.Sprintf(reason, args...)})
+	r.Score = 1 - (1-r.Score)*(1-score)
+}
+
+// FraudAnalyzer - scores receipts against sharing and forgery heuristics, zero value checks structure only.
+type FraudAnalyzer struct {
+	// BundleID - when set, receipts of other apps are flagged.
+	BundleID string
+
+	// Production - sandbox receipts are flagged, App Review still uses sandbox, so they aren't scored as certain fraud.
+	Production bool
+
+	// Links - when set, original transactions presented by more than MaxAccounts users are flagged,
+	// usually Linker.Store, so users are counted as Linker sees them.
+	Links LinkStore
+
+	// MaxAccounts - users per original transaction considered normal, 3 if 0.
+	MaxAccounts int
+
+	// ClockSkew - tolerance of creation date in the future, 5 minutes if 0.
+	ClockSkew time.Duration
+
+	// Now - time.Now if nil.
+	Now func() time.Time
+}
+
+// Analyze - flags receipt validated by Apple.
+func (a *FraudAnalyzer) Analyze(ctx context.Context, res ReceiptResult) (report FraudReport, err error) {
+	a.metadata(&report, res.AppMetadata)
+	transactionsStructure(&report, res.Transactions, 0)
+
+	return report, a.shared(ctx, &report, res.Transactions)
+}
+
+// AnalyzeParsed - flags offline parsed receipt, forged receipts usually break its structure.
+func (a *FraudAnalyzer) AnalyzeParsed(ctx context.Context, p *ParsedReceipt) (report FraudReport, err error) {
+	var meta = p.Metadata()
+
+	a.metadata(&report, meta)
+
+	if len(p.signed.SignerInfos) == 0 || len(p.signed.Certificates.Bytes) == 0 {
+		report.flag(FraudForgedStructure, 1, "receipt isn't signed")
+	}
+
+	if len(p.SHA1Hash) != 20 {
+		report.flag(FraudForgedStructure, 0.8, "receipt hash is %d bytes, not 20", len(p.SHA1Hash))
+	}
+
+	if len(p.OpaqueValue) == 0 {
+		report.flag(FraudForgedStructure, 0.6, "receipt has no opaque value")
+	}
+
+	if meta.BundleID == "" || meta.ApplicationVersion == "" {
+		report.flag(FraudForgedStructure, 0.8, "receipt has no bundle id or application version")
+	}
+
+	if meta.CreatedAt == 0 {
+		report.flag(FraudForgedStructure, 0.6, "receipt has no creation date")
+	}
+
+	transactions, err := p.Transactions()
+	if err != nil {
+		report.flag(FraudForgedStructure, 0.8, "receipt transactions can't be read: %v", err)
+		return report, nil
+	}
+
+	var createdBefore int64
+	if meta.CreatedAt != 0 {
+		createdBefore = meta.CreatedAt + int64(a.clockSkew()/time.Second)
+	}
+
+	transactionsStructure(&report, transactions, createdBefore)
+
+	return report, a.shared(ctx, &report, transactions)
+}
+
+// metadata - environment, bundle and creation date checks.
+func (a *FraudAnalyzer) metadata(report *FraudReport, meta AppMetadata) {
+	if a.Production && strings.Contains(strings.ToLower(meta.Environment), "sandbox") {
+		report.flag(FraudSandboxInProduction, 0.5, "%s receipt in production", meta.Environment)
+	}
+
+	if a.BundleID != "" && meta.BundleID != a.BundleID {
+		report.flag(FraudBundleMismatch, 1, "receipt of bundle %q, expected %q", meta.BundleID, a.BundleID)
+	}
+
+	if now := a.now(); meta.CreatedAt > now.Add(a.clockSkew()).Unix() {
+		report.flag(FraudFutureCreation, 0.9, "receipt created %s in the future",
+			time.Unix(meta.CreatedAt, 0).Sub(now).Round(time.Second))
+	}
+}
+
+// transactionsStructure - flags transactions Apple can't issue, createdBefore is skipped if 0.
+func transactionsStructure(report *FraudReport, transactions []Transaction, createdBefore int64) {
+	for _, t := range transactions {
+		switch {
+		case !digits(t.ID) || !digits(t.OriginalID):
+			report.flag(FraudForgedStructure, 0.7, "transaction %q of %q has malformed id", t.ID, t.OriginalID)
+		case t.SubscriptionExpireAt != 0 && t.SubscriptionExpireAt < t.PurchasedAt:
+			report.flag(FraudForgedStructure, 0.7, "transaction %s expires before purchase", t.ID)
+		case createdBefore != 0 && t.PurchasedAt > createdBefore:
+			report.flag(FraudForgedStructure, 0.7, "transaction %s purchased after receipt creation", t.ID)
+		}
+	}
+}
+
+// shared - flags original transactions presented by too many users.
+func (a *FraudAnalyzer) shared(ctx context.Context, report *FraudReport, transactions []Transaction) error {
+	if a.Links == nil {
+		return nil
+	}
+
+	var (
+		limit   = a.MaxAccounts
+		checked = make(map[string]struct{})
+	)
+
+	if limit <= 0 {
+		limit = 3
+	}
+
+	for _, t := range transactions {
+		if _, ok := checked[t.OriginalID]; ok {
+			continue
+		}
+
+		checked[t.OriginalID] = struct{}{}
+
+		users, err := a.Links.Users(ctx, t.OriginalID)
+		if err != nil {
+			return errors.Wrap(err, "links Users")
+		}
+
+		if over := len(users) - limit; over > 0 {
+			var score = 0.5 + 0.1*float64(over)
+			if score > 1 {
+				score = 1
+			}
+
+			report.flag(FraudSharedPurchase, score, "original transaction %s presented by %d accounts, limit %d",
+				t.OriginalID, len(users), limit)
+		}
+	}
+
+	return nil
+}
+
+func (a *FraudAnalyzer) clockSkew() time.Duration {
+	if a.ClockSkew > 0 {
+		return a.ClockSkew
+	}
+
+	return 5 * time.Minute
+}
+
+func (a *FraudAnalyzer) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+
+	return time.Now()
+}
+
+// digits - s is a non empty decimal number, like every Apple transaction id.
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,51 +2,107 @@ package AppleTransactions
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // all failure apple statuses https://developer.apple.com/documentation/appstorereceipts/status
 
+// StatusError - apple status != 0, formatted as the status number.
+type StatusError int
+
+func (e StatusError) Error() string {
+	return strconv.Itoa(int(e))
+}
+
+// Temporary - Apple asks to try again later.
+func (e StatusError) Temporary() bool {
+	return e == 21002 || e == 21005 || e == 21009 || e >= 21100 && e <= 21199
+}
+
+// HTTPError - verifyReceipt answered with non-2xx HTTP status other than 429, body wasn't decoded.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter - Retry-After of 503 response, 0 if missing.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d", e.StatusCode)
+}
+
+// Temporary - Apple or a proxy in front of it failed, request may succeed later.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500
+}
+
 // Transaction - transaction data from apple.
 type Transaction struct {
-	ID        string
-	InAppName string
+	ID         string
+	OriginalID string
+	InAppName  string
+	Quantity   int
 
 	// SubscriptionExpireAt - Unix timestamp.
 	// 0 if it's not subscribe inapp.
 	SubscriptionExpireAt int64
+
+	// AccessExpireAt - Unix timestamp until user keeps access, see AccessExpiry.
+	// 0 if it's not subscribe inapp.
+	AccessExpireAt int64
+
+	// PurchasedAt - Unix timestamp.
+	PurchasedAt int64
+
+	// SubscriptionGroup - empty for non-subscriptions and receipt in_app entries.
+	SubscriptionGroup    string
+	IsTrialPeriod        bool
+	IsInIntroOfferPeriod bool
+
+	// IsUpgraded - subscription was replaced by an upgrade to another product of the group.
+	IsUpgraded bool
+
+	// OwnershipType - empty if Apple didn't send it, which means OwnershipPurchased.
+	OwnershipType OwnershipType
+
+	// RevokedAt - Unix timestamp of refund or revoked family access.
+	// 0 if it's not revoked.
+	RevokedAt int64
+
+	// ProductType - sent only by StoreKit 2, use Catalog to classify receipt transactions.
+	ProductType ProductType
+
+	// AppAccountToken - lowercase UUID app passed on purchase, empty if it passed none, see Linker.
+	AppAccountToken string
 }
 
-// TransactionsByReceipt - retrieve all transactions by apple receipt.
-//
-// Apple status != 0 will return in error as string.
-func TransactionsByReceipt(receipt, sharedPassword string) (res []Transaction, err error) {
-	var req = appleQuery{
-		ReceiptData: receipt,
-		Password:    sharedPassword,
-	}
+// ReceiptResult - verifyReceipt result with app metadata of the receipt.
+type ReceiptResult struct {
+	AppMetadata
 
-	resp, err := req.query(false)
-	if err != nil {
-		return res, errors.Wrap(err, "apple query(sandbox:false)")
-	}
+	Transactions []Transaction
+	RenewalInfo  []RenewalInfo
 
-	if resp.Status == 21007 {
-		resp, err = req.query(true)
-		if err != nil {
-			return res, errors.Wrap(err, "apple query(sandbox:true)")
-		}
-	}
+	// LatestReceipt - latest base64 receipt, only for receipts with auto-renewable subscriptions.
+	LatestReceipt string
+}
 
-	if resp.Status != 0 {
-		return res, errors.New(strconv.Itoa(resp.Status))
-	}
+// TransactionsByReceipt - retrieve all transactions by apple receipt.
+//
+// Apple status != 0 will return in error as string.
+func TransactionsByReceipt(receipt, sharedPassword string, opts ...ReceiptOption) (res []Transaction, err error) {
+	return defaultClient.TransactionsByReceipt(context.Background(), receipt, sharedPassword, opts...)
+}
 
-	return resp.collectTransactions()
+// ValidateReceipt - like TransactionsByReceipt, but with receipt metadata.
+func ValidateReceipt(receipt, sharedPassword string, opts ...ReceiptOption) (res ReceiptResult, err error) {
+	return defaultClient.ValidateReceipt(context.Background(), receipt, sharedPassword, opts...)
 }
 
 // appleQuery - json payload for apple
@@ -57,7 +113,7 @@ type appleQuery struct {
 	Password string `json:"password,omitempty"`
 }
 
-func (q *appleQuery) query(sandbox bool) (res receiptData, err error) {
+func (q *appleQuery) query(ctx context.Context, hc *http.Client, sandbox bool) (res receiptData, err error) {
 	var appStoreURL string
 
 	if sandbox {
@@ -73,14 +129,38 @@ func (q *appleQuery) query(sandbox bool) (res receiptData, err error) {
 		return res, errors.Wrap(err, "failed Encode")
 	}
 
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, appStoreURL, buffer)
+	if err != nil {
+		return res, errors.Wrap(err, "failed http.NewRequest")
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
 	// Send receipt to App Store
-	response, err := http.Post(appStoreURL, "application/json", buffer)
+	response, err := hc.Do(request)
 	if err != nil {
 		return res, errors.Wrap(err, "failed http.Post")
 	}
 
 	defer func() { _ = response.Body.Close() }()
 
+	if response.StatusCode == http.StatusTooManyRequests {
+		return res, &RateLimitError{
+			Endpoint:   EndpointVerifyReceipt,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var httpErr = &HTTPError{StatusCode: response.StatusCode}
+
+		if retryAfter := response.Header.Get("Retry-After"); retryAfter != "" && response.StatusCode == http.StatusServiceUnavailable {
+			httpErr.RetryAfter = parseRetryAfter(retryAfter, time.Now())
+		}
+
+		return res, httpErr
+	}
+
 	if err = json.NewDecoder(response.Body).Decode(&res); err != nil {
 		return res, errors.Wrap(err, "failed Decode response")
 	}
@@ -88,24 +168,9 @@ func (q *appleQuery) query(sandbox bool) (res receiptData, err error) {
 	return
 }
 
+// latestReceipt - latest_receipt_info entry, in_app fields plus subscription group.
 type latestReceipt struct {
-	Quantity                    string `json:"quantity"`
-	ProductID                   string `json:"product_id"`
-	TransactionID               string `json:"transaction_id"`
-	OriginalTransactionID       string `json:"original_transaction_id"`
-	PurchaseDate                string `json:"purchase_date"`
-	PurchaseDateMS              string `json:"purchase_date_ms"`
-	PurchaseDatePST             string `json:"purchase_date_pst"`
-	OriginalPurchaseDate        string `json:"original_purchase_date"`
-	OriginalPurchaseDateMS      string `json:"original_purchase_date_ms"`
-	OriginalPurchaseDatePST     string `json:"original_purchase_date_pst"`
-	ExpiresDate                 string `json:"expires_date"`
-	ExpiresDateMS               string `json:"expires_date_ms"`
-	ExpiresDatePST              string `json:"expires_date_pst"`
-	WebOrderLineItemID          string `json:"web_order_line_item_id"`
-	IsTrialPeriod               string `json:"is_trial_period"`
-	IsInIntroOfferPeriod        string `json:"is_in_intro_offer_period"`
-	InAppOwnershipType          string `json:"in_app_ownership_type"`
+	inApp
 	SubscriptionGroupIdentifier string `json:"subscription_group_identifier"`
 }
 
@@ -116,6 +181,8 @@ type pendingRenewalInfo struct {
 	ProductID              string `json:"product_id"`
 	OriginalTransactionID  string `json:"original_transaction_id"`
 	AutoRenewStatus        string `json:"auto_renew_status"`
+	GracePeriodExpiresDate string `json:"grace_period_expires_date"`
+	GracePeriodExpiresMS   string `json:"grace_period_expires_date_ms"`
 }
 
 type receipt struct {
@@ -153,10 +220,14 @@ type inApp struct {
 	ExpiresDate             string `json:"expires_date"`
 	ExpiresDateMS           string `json:"expires_date_ms"`
 	ExpiresDatePST          string `json:"expires_date_pst"`
+	CancellationDate        string `json:"cancellation_date"`
+	CancellationDateMS      string `json:"cancellation_date_ms"`
 	WebOrderLineItemID      string `json:"web_order_line_item_id"`
 	IsTrialPeriod           string `json:"is_trial_period"`
 	IsInIntroOfferPeriod    string `json:"is_in_intro_offer_period"`
 	InAppOwnershipType      string `json:"in_app_ownership_type"`
+	IsUpgraded              string `json:"is_upgraded"`
+	AppAccountToken         string `json:"app_account_token"`
 }
 
 // ReceiptData - ReceiptValidationResult contains validation result returned to client
@@ -176,7 +247,12 @@ func msToTime(ms string) (int64, error) {
 		return 0, err
 	}
 
-	return time.UnixMilli(msInt).Unix(), nil
+	return msToUnix(msInt), nil
+}
+
+// msToUnix - int milliseconds to int unix time.
+func msToUnix(ms int64) int64 {
+	return time.UnixMilli(ms).Unix()
 }
 
 // collectTransactions - will return transactions with unique transaction_id.
@@ -184,37 +260,26 @@ func (r *receiptData) collectTransactions() (res []Transaction, err error) {
 	var unique = make(map[string]Transaction)
 
 	for _, v := range r.LatestReceiptInfo {
-		var expires int64
-
-		if v.ExpiresDateMS != "" {
-			expires, err = msToTime(v.ExpiresDateMS)
-			if err != nil {
-				return res, errors.Wrap(err, "msToTime1 fail")
-			}
+		t, err := v.transaction()
+		if err != nil {
+			return res, errors.Wrap(err, "msToTime1 fail")
 		}
 
-		unique[v.TransactionID] = Transaction{
-			ID:                   v.TransactionID,
-			InAppName:            v.ProductID,
-			SubscriptionExpireAt: expires,
-		}
+		unique[v.TransactionID] = t
 	}
 
 	for _, v := range r.Receipt.InApp {
-		var expires int64
-
-		if v.ExpiresDateMS != "" {
-			expires, err = msToTime(v.ExpiresDateMS)
-			if err != nil {
-				return res, errors.Wrap(err, "msToTime2 fail")
-			}
+		// latest_receipt_info has more fields of the same transaction
+		if _, ok := unique[v.TransactionID]; ok {
+			continue
 		}
 
-		unique[v.TransactionID] = Transaction{
-			ID:                   v.TransactionID,
-			InAppName:            v.ProductID,
-			SubscriptionExpireAt: expires,
+		t, err := v.transaction()
+		if err != nil {
+			return res, errors.Wrap(err, "msToTime2 fail")
 		}
+
+		unique[v.TransactionID] = t
 	}
 
 	for _, v := range unique {
@@ -223,3 +288,51 @@ func (r *receiptData) collectTransactions() (res []Transaction, err error) {
 
 	return
 }
+
+func (v *latestReceipt) transaction() (res Transaction, err error) {
+	if res, err = v.inApp.transaction(); err != nil {
+		return res, err
+	}
+
+	res.SubscriptionGroup = v.SubscriptionGroupIdentifier
+
+	return res, nil
+}
+
+func (v *inApp) transaction() (res Transaction, err error) {
+	res = Transaction{
+		ID:                   v.TransactionID,
+		OriginalID:           v.OriginalTransactionID,
+		InAppName:            v.ProductID,
+		IsTrialPeriod:        v.IsTrialPeriod == "true",
+		IsInIntroOfferPeriod: v.IsInIntroOfferPeriod == "true",
+		IsUpgraded:           v.IsUpgraded == "true",
+		OwnershipType:        OwnershipType(v.InAppOwnershipType),
+		AppAccountToken:      strings.ToLower(v.AppAccountToken),
+	}
+
+	if v.Quantity != "" {
+		if res.Quantity, err = strconv.Atoi(v.Quantity); err != nil {
+			return res, err
+		}
+	}
+
+	if res.SubscriptionExpireAt, err = unixFromMS(v.ExpiresDateMS); err != nil {
+		return res, err
+	}
+
+	res.AccessExpireAt = res.SubscriptionExpireAt
+
+	if res.PurchasedAt, err = unixFromMS(v.PurchaseDateMS); err != nil {
+		return res, err
+	}
+
+	revokedAt, err := unixFromMS(v.CancellationDateMS)
+	if err != nil {
+		return res, err
+	}
+
+	res.revoke(revokedAt)
+
+	return res, nil
+}
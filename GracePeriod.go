@@ -0,0 +1,42 @@
+package AppleTransactions
+
+// AccessExpiry - until when user should keep access to subscription t.
+//
+// While Apple retries billing within grace period, user keeps access
+// after SubscriptionExpireAt until GracePeriodExpiresAt.
+// Revoked subscription ends at RevokedAt.
+// info must be renewal info of t's original transaction, may be nil.
+func (t Transaction) AccessExpiry(info *RenewalInfo) int64 {
+	var expires = t.SubscriptionExpireAt
+
+	if info != nil && info.IsInBillingRetryPeriod && info.GracePeriodExpiresAt > expires {
+		expires = info.GracePeriodExpiresAt
+	}
+
+	if t.RevokedAt != 0 && expires != 0 && t.RevokedAt < expires {
+		expires = t.RevokedAt
+	}
+
+	return expires
+}
+
+// applyGracePeriod - sets AccessExpireAt of latest transaction of every renewal info.
+func applyGracePeriod(transactions []Transaction, renewals []RenewalInfo) {
+	for i := range renewals {
+		var latest = -1
+
+		for j, t := range transactions {
+			if t.OriginalID != renewals[i].OriginalTransactionID || t.SubscriptionExpireAt == 0 {
+				continue
+			}
+
+			if latest == -1 || t.SubscriptionExpireAt > transactions[latest].SubscriptionExpireAt {
+				latest = j
+			}
+		}
+
+		if latest != -1 {
+			transactions[latest].AccessExpireAt = transactions[latest].AccessExpiry(&renewals[i])
+		}
+	}
+}
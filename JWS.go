@@ -0,0 +1,210 @@
+package AppleTransactions
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Apple marks certificates of the App Store signing chain with these extensions.
+// https://www.apple.com/certificateauthority/
+var (
+	oidAppleLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// JWSVerifier - verifies JWS payloads signed by the App Store (notifications V2, StoreKit 2 transactions).
+type JWSVerifier struct {
+	// RootCertificates - trusted Apple roots, usually "Apple Root CA - G3".
+	RootCertificates *x509.CertPool
+
+	// Now - clock used for certificate validity, time.Now if nil.
+	Now func() time.Time
+
+	// BundleID - when set, transactions and AppTransaction of other apps are rejected.
+	BundleID string
+
+	// Environment - when set, like "Production", transactions and AppTransaction of other environments are rejected.
+	Environment string
+
+	// AppAppleID - when set, AppTransaction of other apps is rejected.
+	// App Store assigns it in production only, so it isn't required of sandbox AppTransaction.
+	AppAppleID int64
+}
+
+// NewJWSVerifier - verifier trusting given root certificates (PEM or DER).
+func NewJWSVerifier(rootCertificates ...[]byte) (*JWSVerifier, error) {
+	certs, err := parseCertificates(rootCertificates)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool = x509.NewCertPool()
+
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return &JWSVerifier{RootCertificates: pool}, nil
+}
+
+// parseCertificates - PEM or DER encoded certificates.
+func parseCertificates(raws [][]byte) (res []*x509.Certificate, err error) {
+	for _, raw := range raws {
+		if block, _ := pem.Decode(raw); block != nil {
+			raw = block.Bytes
+		}
+
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed ParseCertificate")
+		}
+
+		res = append(res, cert)
+	}
+
+	return res, nil
+}
+
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	X5C []string `json:"x5c"`
+}
+
+// Verify - checks signature and certificate chain of signed and decodes its payload into dst.
+func (v *JWSVerifier) Verify(signed string, dst interface{}) error {
+	if v == nil || v.RootCertificates == nil {
+		return errors.New("no root certificates")
+	}
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed jws")
+	}
+
+	var header jwsHeader
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return errors.Wrap(err, "failed decode header")
+	}
+
+	if header.Alg != "ES256" {
+		return errors.Errorf("unsupported alg %q", header.Alg)
+	}
+
+	leaf, err := v.verifyChain(header.X5C)
+	if err != nil {
+		return errors.Wrap(err, "verifyChain fail")
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("leaf key is not ecdsa")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return errors.New("malformed signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return errors.New("invalid signature")
+	}
+
+	if err = decodeSegment(parts[1], dst); err != nil {
+		return errors.Wrap(err, "failed decode payload")
+	}
+
+	return nil
+}
+
+// verifyChain - x5c must be leaf, Apple intermediate and a certificate chaining to RootCertificates.
+func (v *JWSVerifier) verifyChain(x5c []string) (*x509.Certificate, error) {
+	if len(x5c) != 3 {
+		return nil, errors.Errorf("expected 3 certificates, got %d", len(x5c))
+	}
+
+	var certs = make([]*x509.Certificate, len(x5c))
+
+	for i, s := range x5c {
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed decode x5c")
+		}
+
+		if certs[i], err = x509.ParseCertificate(raw); err != nil {
+			return nil, errors.Wrap(err, "failed ParseCertificate")
+		}
+	}
+
+	if !hasExtension(certs[0], oidAppleLeaf) || !hasExtension(certs[1], oidAppleIntermediate) {
+		return nil, errors.New("not an App Store certificate chain")
+	}
+
+	var intermediates = x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         v.RootCertificates,
+		Intermediates: intermediates,
+		CurrentTime:   v.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+// checkApp - bundleId and environment of a payload against BundleID and Environment.
+func (v *JWSVerifier) checkApp(bundleID, environment string) error {
+	if v.BundleID != "" && bundleID != v.BundleID {
+		return errors.Errorf("unexpected bundleId %q", bundleID)
+	}
+
+	if v.Environment != "" && environment != v.Environment {
+		return errors.Errorf("unexpected environment %q", environment)
+	}
+
+	return nil
+}
+
+func (v *JWSVerifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+
+	return time.Now()
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeSegment - base64url json segment of jws into dst.
+func decodeSegment(segment string, dst interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, dst)
+}
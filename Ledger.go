@@ -0,0 +1,235 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// LedgerEntry - consumable delivered to a user.
+type LedgerEntry struct {
+	TransactionID string
+	UserID        string
+	ProductID     string
+	Quantity      int
+
+	// CreditedAt - Unix timestamp, 0 if refund arrived before credit and the entry only blocks crediting.
+	CreditedAt int64
+	// ReversedAt - Unix timestamp of refund, 0 if entry wasn't reversed.
+	ReversedAt int64
+}
+
+// LedgerStore - persists delivered consumables, implementations must be safe for concurrent use.
+type LedgerStore interface {
+	// Credit - records e, false if its transaction was already credited.
+	Credit(ctx context.Context, e LedgerEntry) (bool, error)
+
+	// Reverse - marks credit of transactionID refunded, false if it wasn't credited or is already reversed.
+	// Refund of transaction not credited yet is recorded too, so transactionID is never credited afterwards.
+	Reverse(ctx context.Context, transactionID string, at int64) (bool, error)
+
+	// Balance - credited and not reversed quantity of productID for userID.
+	Balance(ctx context.Context, userID, productID string) (int, error)
+}
+
+// Ledger - credits consumable purchases exactly once, however often receipt is validated.
+//
+// Consumables stay in receipt in_app only until they are finished,
+// so credit them as soon as they show up.
+type Ledger struct {
+	Store   LedgerStore
+	Catalog Catalog
+}
+
+// NewLedger - ledger over store, catalog tells consumables apart.
+func NewLedger(store LedgerStore, catalog Catalog) *Ledger {
+	return &Ledger{Store: store, Catalog: catalog}
+}
+
+// Credit - credits userID with consumables of transactions not credited before and reverses revoked ones.
+//
+// Returns entries credited by this call only, concurrent calls never credit one transaction twice.
+func (l *Ledger) Credit(ctx context.Context, userID string, transactions []Transaction) (res []LedgerEntry, err error) {
+	for _, t := range transactions {
+		if l.Catalog.Classify(t) != ProductConsumable {
+			continue
+		}
+
+		if t.RevokedAt != 0 {
+			if _, err = l.Store.Reverse(ctx, t.ID, t.RevokedAt); err != nil {
+				return res, errors.Wrap(err, "ledger Reverse")
+			}
+			continue
+		}
+
+		var e = LedgerEntry{
+			TransactionID: t.ID,
+			UserID:        userID,
+			ProductID:     t.InAppName,
+			Quantity:      t.Quantity,
+			CreditedAt:    time.Now().Unix(),
+		}
+
+		if e.Quantity == 0 {
+			e.Quantity = 1
+		}
+
+		credited, err := l.Store.Credit(ctx, e)
+		if err != nil {
+			return res, errors.Wrap(err, "ledger Credit")
+		}
+
+		if credited {
+			res = append(res, e)
+		}
+	}
+
+	return res, nil
+}
+
+// Refund - reverses credit of transactionID, for REFUND notifications.
+//
+// Notification may outrun receipt validation, refunded transaction isn't credited by later Credit calls.
+func (l *Ledger) Refund(ctx context.Context, transactionID string, at int64) (bool, error) {
+	return l.Store.Reverse(ctx, transactionID, at)
+}
+
+// MemoryLedgerStore - in-process LedgerStore, forgets everything on restart.
+type MemoryLedgerStore struct {
+	mu      sync.Mutex
+	entries map[string]LedgerEntry
+}
+
+// NewMemoryLedgerStore - empty MemoryLedgerStore.
+func NewMemoryLedgerStore() *MemoryLedgerStore {
+	return &MemoryLedgerStore{entries: make(map[string]LedgerEntry)}
+}
+
+func (s *MemoryLedgerStore) Credit(_ context.Context, e LedgerEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[e.TransactionID]; ok {
+		return false, nil
+	}
+
+	s.entries[e.TransactionID] = e
+
+	return true, nil
+}
+
+func (s *MemoryLedgerStore) Reverse(_ context.Context, transactionID string, at int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[transactionID]
+	if !ok {
+		s.entries[transactionID] = LedgerEntry{TransactionID: transactionID, ReversedAt: at}
+		return false, nil
+	}
+
+	if e.ReversedAt != 0 {
+		return false, nil
+	}
+
+	e.ReversedAt = at
+	s.entries[transactionID] = e
+
+	return true, nil
+}
+
+func (s *MemoryLedgerStore) Balance(_ context.Context, userID, productID string) (res int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.UserID == userID && e.ProductID == productID && e.ReversedAt == 0 {
+			res += e.Quantity
+		}
+	}
+
+	return res, nil
+}
+
+// SQLLedgerStore - LedgerStore on database/sql, works with SQLite and Postgres.
+//
+// Primary key on transaction_id makes crediting exactly once across processes.
+type SQLLedgerStore struct {
+	DB *sql.DB
+}
+
+// NewSQLLedgerStore - SQLLedgerStore on db, call Migrate before use.
+func NewSQLLedgerStore(db *sql.DB) *SQLLedgerStore {
+	return &SQLLedgerStore{DB: db}
+}
+
+// Migrate - creates table if it doesn't exist.
+func (s *SQLLedgerStore) Migrate(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS apple_ledger (
+		transaction_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		product_id TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		credited_at BIGINT NOT NULL,
+		reversed_at BIGINT NOT NULL DEFAULT 0
+	)`)
+
+	return errors.Wrap(err, "failed create table")
+}
+
+func (s *SQLLedgerStore) Credit(ctx context.Context, e LedgerEntry) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_ledger (transaction_id, user_id, product_id, quantity, credited_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (transaction_id) DO NOTHING`,
+		e.TransactionID, e.UserID, e.ProductID, e.Quantity, e.CreditedAt)
+	if err != nil {
+		return false, errors.Wrap(err, "failed insert entry")
+	}
+
+	return affected(res)
+}
+
+func (s *SQLLedgerStore) Reverse(ctx context.Context, transactionID string, at int64) (bool, error) {
+	reversed, err := s.reverse(ctx, transactionID, at)
+	if err != nil || reversed {
+		return reversed, err
+	}
+
+	// entry without credit blocks Credit of transactionID
+	res, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_ledger (transaction_id, user_id, product_id, quantity, credited_at, reversed_at)
+		VALUES ($1, '', '', 0, 0, $2) ON CONFLICT (transaction_id) DO NOTHING`,
+		transactionID, at)
+	if err != nil {
+		return false, errors.Wrap(err, "failed insert reversal")
+	}
+
+	blocked, err := affected(res)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	// credited or reversed concurrently
+	return s.reverse(ctx, transactionID, at)
+}
+
+func (s *SQLLedgerStore) reverse(ctx context.Context, transactionID string, at int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE apple_ledger SET reversed_at = $1 WHERE transaction_id = $2 AND reversed_at = 0`,
+		at, transactionID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed update entry")
+	}
+
+	return affected(res)
+}
+
+func (s *SQLLedgerStore) Balance(ctx context.Context, userID, productID string) (res int, err error) {
+	err = s.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(quantity), 0) FROM apple_ledger WHERE user_id = $1 AND product_id = $2 AND reversed_at = 0`,
+		userID, productID).Scan(&res)
+
+	return res, errors.Wrap(err, "failed select balance")
+}
@@ -0,0 +1,84 @@
+package AppleTransactions
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestSQLLedgerStore(t *testing.T) *SQLLedgerStore {
+	t.Helper()
+
+	var s = NewSQLLedgerStore(openSQLite(t))
+
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestLedger(t *testing.T) {
+	for name, store := range map[string]LedgerStore{
+		"memory": NewMemoryLedgerStore(),
+		"sql":    newTestSQLLedgerStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx      = context.Background()
+				ledger   = NewLedger(store, Catalog{"coins": {Type: ProductConsumable}})
+				first    = Transaction{ID: "1", OriginalID: "1", InAppName: "coins", Quantity: 5}
+				second   = Transaction{ID: "2", OriginalID: "2", InAppName: "coins", Quantity: 3}
+				refunded = Transaction{ID: "3", OriginalID: "3", InAppName: "coins", Quantity: 7}
+			)
+
+			balance := func(want int) {
+				t.Helper()
+
+				if got, err := store.Balance(ctx, "user", "coins"); err != nil || got != want {
+					t.Fatalf("balance %d, %v, want %d", got, err, want)
+				}
+			}
+
+			res, err := ledger.Credit(ctx, "user", []Transaction{first, second})
+			if err != nil || len(res) != 2 {
+				t.Fatalf("credit: %v, %v", res, err)
+			}
+
+			if res, err = ledger.Credit(ctx, "user", []Transaction{first, second}); err != nil || len(res) != 0 {
+				t.Fatalf("credit again: %v, %v", res, err)
+			}
+
+			balance(8)
+
+			if reversed, err := ledger.Refund(ctx, second.ID, 100); err != nil || !reversed {
+				t.Fatalf("refund: %v, %v", reversed, err)
+			}
+
+			if reversed, err := ledger.Refund(ctx, second.ID, 101); err != nil || reversed {
+				t.Fatalf("refund again: %v, %v", reversed, err)
+			}
+
+			balance(5)
+
+			// REFUND notification outran the receipt
+			if reversed, err := ledger.Refund(ctx, refunded.ID, 200); err != nil || reversed {
+				t.Fatalf("refund before credit: %v, %v", reversed, err)
+			}
+
+			if res, err = ledger.Credit(ctx, "user", []Transaction{refunded}); err != nil || len(res) != 0 {
+				t.Fatalf("credit after refund: %v, %v", res, err)
+			}
+
+			balance(5)
+
+			// revoked transaction in receipt reverses its credit
+			first.RevokedAt = 300
+
+			if res, err = ledger.Credit(ctx, "user", []Transaction{first}); err != nil || len(res) != 0 {
+				t.Fatalf("credit revoked: %v, %v", res, err)
+			}
+
+			balance(0)
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LinkPolicy - what Linker does when a user presents purchase linked to another user.
+type LinkPolicy int
+
+const (
+	// LinkFirstWins - purchase stays with the first user, others don't get it.
+	LinkFirstWins LinkPolicy = iota
+	// LinkLastWins - purchase moves to the user who presented it last, like restoring on a new account.
+	LinkLastWins
+	// LinkReject - Link fails with ErrLinkedToAnotherUser.
+	LinkReject
+)
+
+// ErrLinkedToAnotherUser - purchase belongs to another user and LinkReject is set.
+var ErrLinkedToAnotherUser = errors.New("original transaction is linked to another user")
+
+// SharedPurchase - original transaction presented by user other than its owner.
+type SharedPurchase struct {
+	OriginalID string
+	OwnerID    string
+	UserID     string
+
+	// Users - distinct users who ever presented the original transaction, including this one.
+	Users int
+
+	// Transferred - purchase moved from OwnerID to UserID.
+	Transferred bool
+}
+
+// LinkStore - persists user to original transaction links, implementations must be safe for concurrent use.
+type LinkStore interface {
+	// Claim - links originalID to userID unless it's linked already, returns owner after the call.
+	Claim(ctx context.Context, originalID, userID string, at int64) (string, error)
+
+	// Transfer - moves originalID from user from to user to, false if from isn't the owner anymore.
+	Transfer(ctx context.Context, originalID, from, to string, at int64) (bool, error)
+
+	// Seen - records userID presented originalID.
+	Seen(ctx context.Context, originalID, userID string, at int64) error
+
+	// Users - distinct users who presented originalID, in order of first presentation.
+	Users(ctx context.Context, originalID string) ([]string, error)
+}
+
+// Linker - maps Apple purchases to accounts of the app by original transaction ID.
+type Linker struct {
+	Store  LinkStore
+	Policy LinkPolicy
+
+	// AccountToken - appAccountToken app passes on purchase for userID, may be nil.
+	// Transaction carrying token of userID always links to userID,
+	// transaction carrying token of someone else never does.
+	AccountToken func(userID string) string
+
+	// OnShared - called for every purchase presented by user other than its owner, may be nil.
+	OnShared func(ctx context.Context, s SharedPurchase)
+}
+
+// NewLinker - linker over store with policy.
+func NewLinker(store LinkStore, policy LinkPolicy) *Linker {
+	return &Linker{Store: store, Policy: policy}
+}
+
+// Link - links purchases of transactions presented by userID, returns transactions linked to userID.
+func (l *Linker) Link(ctx context.Context, userID string, transactions []Transaction) (res []Transaction, err error) {
+	var (
+		now    = time.Now().Unix()
+		linked = make(map[string]bool)
+	)
+
+	for _, t := range transactions {
+		if _, ok := linked[t.OriginalID]; !ok {
+			if linked[t.OriginalID], err = l.link(ctx, userID, t, now); err != nil {
+				return nil, err
+			}
+		}
+
+		if linked[t.OriginalID] {
+			res = append(res, t)
+		}
+	}
+
+	return res, nil
+}
+
+// link - links purchase of t to userID according to Policy, true if userID owns it afterwards.
+func (l *Linker) link(ctx context.Context, userID string, t Transaction, now int64) (bool, error) {
+	if err := l.Store.Seen(ctx, t.OriginalID, userID, now); err != nil {
+		return false, errors.Wrap(err, "link Seen")
+	}
+
+	var token = l.token(userID, t)
+
+	if token < 0 {
+		// purchase made by another account of the app
+		if err := l.shared(ctx, userID, t.OriginalID, "", false); err != nil {
+			return false, err
+		}
+
+		return false, l.reject(t.OriginalID)
+	}
+
+	owner, err := l.Store.Claim(ctx, t.OriginalID, userID, now)
+	if err != nil {
+		return false, errors.Wrap(err, "link Claim")
+	}
+
+	if owner == userID {
+		return true, nil
+	}
+
+	var transfer = token > 0 || l.Policy == LinkLastWins
+
+	if transfer {
+		if transfer, err = l.Store.Transfer(ctx, t.OriginalID, owner, userID, now); err != nil {
+			return false, errors.Wrap(err, "link Transfer")
+		}
+	}
+
+	if err = l.shared(ctx, userID, t.OriginalID, owner, transfer); err != nil {
+		return false, err
+	}
+
+	if !transfer {
+		return false, l.reject(t.OriginalID)
+	}
+
+	return true, nil
+}
+
+// reject - ErrLinkedToAnotherUser for LinkReject, nil for other policies.
+func (l *Linker) reject(originalID string) error {
+	if l.Policy != LinkReject {
+		return nil
+	}
+
+	return errors.Wrapf(ErrLinkedToAnotherUser, "original transaction %s", originalID)
+}
+
+// token - 1 if t carries appAccountToken of userID, -1 if of another user, 0 if it can't tell.
+func (l *Linker) token(userID string, t Transaction) int {
+	if l.AccountToken == nil || t.AppAccountToken == "" {
+		return 0
+	}
+
+	if strings.EqualFold(l.AccountToken(userID), t.AppAccountToken) {
+		return 1
+	}
+
+	return -1
+}
+
+func (l *Linker) shared(ctx context.Context, userID, originalID, owner string, transferred bool) error {
+	if l.OnShared == nil {
+		return nil
+	}
+
+	users, err := l.Store.Users(ctx, originalID)
+	if err != nil {
+		return errors.Wrap(err, "link Users")
+	}
+
+	l.OnShared(ctx, SharedPurchase{
+		OriginalID:  originalID,
+		OwnerID:     owner,
+		UserID:      userID,
+		Users:       len(users),
+		Transferred: transferred,
+	})
+
+	return nil
+}
+
+// MemoryLinkStore - in-process LinkStore, forgets everything on restart.
+type MemoryLinkStore struct {
+	mu     sync.Mutex
+	owners map[string]string
+	users  map[string][]string
+}
+
+// NewMemoryLinkStore - empty MemoryLinkStore.
+func NewMemoryLinkStore() *MemoryLinkStore {
+	return &MemoryLinkStore{
+		owners: make(map[string]string),
+		users:  make(map[string][]string),
+	}
+}
+
+func (s *MemoryLinkStore) Claim(_ context.Context, originalID, userID string, _ int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if owner, ok := s.owners[originalID]; ok {
+		return owner, nil
+	}
+
+	s.owners[originalID] = userID
+
+	return userID, nil
+}
+
+func (s *MemoryLinkStore) Transfer(_ context.Context, originalID, from, to string, _ int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.owners[originalID] != from {
+		return false, nil
+	}
+
+	s.owners[originalID] = to
+
+	return true, nil
+}
+
+func (s *MemoryLinkStore) Seen(_ context.Context, originalID, userID string, _ int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users[originalID] {
+		if u == userID {
+			return nil
+		}
+	}
+
+	s.users[originalID] = append(s.users[originalID], userID)
+
+	return nil
+}
+
+func (s *MemoryLinkStore) Users(_ context.Context, originalID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.users[originalID]...), nil
+}
+
+// SQLLinkStore - LinkStore on database/sql, works with SQLite and Postgres.
+type SQLLinkStore struct {
+	DB *sql.DB
+}
+
+// NewSQLLinkStore - SQLLinkStore on db, call Migrate before use.
+func NewSQLLinkStore(db *sql.DB) *SQLLinkStore {
+	return &SQLLinkStore{DB: db}
+}
+
+// Migrate - creates tables if they don't exist.
+func (s *SQLLinkStore) Migrate(ctx context.Context) error {
+	for _, q := range []string{
+		`CREATE TABLE IF NOT EXISTS apple_links (
+			original_transaction_id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			linked_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS apple_link_users (
+			original_transaction_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			seen_at BIGINT NOT NULL,
+			PRIMARY KEY (original_transaction_id, user_id)
+		)`,
+	} {
+		if _, err := s.DB.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "failed create table")
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLLinkStore) Claim(ctx context.Context, originalID, userID string, at int64) (owner string, err error) {
+	_, err = s.DB.ExecContext(ctx,
+		`INSERT INTO apple_links (original_transaction_id, user_id, linked_at) VALUES ($1, $2, $3)
+		ON CONFLICT (original_transaction_id) DO NOTHING`,
+		originalID, userID, at)
+	if err != nil {
+		return "", errors.Wrap(err, "failed insert link")
+	}
+
+	err = s.DB.QueryRowContext(ctx,
+		`SELECT user_id FROM apple_links WHERE original_transaction_id = $1`, originalID).Scan(&owner)
+
+	return owner, errors.Wrap(err, "failed select link")
+}
+
+func (s *SQLLinkStore) Transfer(ctx context.Context, originalID, from, to string, at int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE apple_links SET user_id = $1, linked_at = $2 WHERE original_transaction_id = $3 AND user_id = $4`,
+		to, at, originalID, from)
+	if err != nil {
+		return false, errors.Wrap(err, "failed update link")
+	}
+
+	return affected(res)
+}
+
+func (s *SQLLinkStore) Seen(ctx context.Context, originalID, userID string, at int64) error {
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_link_users (original_transaction_id, user_id, seen_at) VALUES ($1, $2, $3)
+		ON CONFLICT (original_transaction_id, user_id) DO NOTHING`,
+		originalID, userID, at)
+
+	return errors.Wrap(err, "failed insert link user")
+}
+
+func (s *SQLLinkStore) Users(ctx context.Context, originalID string) (res []string, err error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT user_id FROM apple_link_users WHERE original_transaction_id = $1 ORDER BY seen_at, user_id`,
+		originalID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select link users")
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var user string
+
+		if err = rows.Scan(&user); err != nil {
+			return nil, errors.Wrap(err, "failed Scan")
+		}
+
+		res = append(res, user)
+	}
+
+	return res, errors.Wrap(rows.Err(), "failed rows")
+}
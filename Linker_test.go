@@ -0,0 +1,77 @@
+package AppleTransactions
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func newTestSQLLinkStore(t *testing.T) *SQLLinkStore {
+	t.Helper()
+
+	var s = NewSQLLinkStore(openSQLite(t))
+
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestLinkerPolicies(t *testing.T) {
+	var purchase = []Transaction{{ID: "1", OriginalID: "1", InAppName: "premium"}}
+
+	for name, newStore := range map[string]func(t *testing.T) LinkStore{
+		"memory": func(*testing.T) LinkStore { return NewMemoryLinkStore() },
+		"sql":    func(t *testing.T) LinkStore { return newTestSQLLinkStore(t) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var ctx = context.Background()
+
+			for policy, want := range map[LinkPolicy]string{LinkFirstWins: "alice", LinkLastWins: "bob"} {
+				var (
+					shared []SharedPurchase
+					l      = NewLinker(newStore(t), policy)
+				)
+
+				l.OnShared = func(_ context.Context, s SharedPurchase) { shared = append(shared, s) }
+
+				for _, user := range []string{"alice", "bob"} {
+					if _, err := l.Link(ctx, user, purchase); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				owner, err := l.Store.Claim(ctx, "1", "carol", 0)
+				if err != nil || owner != want {
+					t.Errorf("policy %d: owner %q, %v, want %q", policy, owner, err, want)
+				}
+
+				if len(shared) != 1 || shared[0].OwnerID != "alice" || shared[0].Transferred != (policy == LinkLastWins) {
+					t.Errorf("policy %d: shared %+v", policy, shared)
+				}
+			}
+
+			var l = NewLinker(newStore(t), LinkFirstWins)
+
+			l.AccountToken = func(userID string) string { return "token-" + userID }
+
+			var tokened = []Transaction{{ID: "2", OriginalID: "2", InAppName: "premium", AppAccountToken: "token-bob"}}
+
+			for _, user := range []string{"alice", "bob", "carol"} {
+				if _, err := l.Link(ctx, user, tokened); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// token of bob moves the purchase to bob whoever presented it first
+			if owner, err := l.Store.Claim(ctx, "2", "dave", 0); err != nil || owner != "bob" {
+				t.Errorf("token: owner %q, %v", owner, err)
+			}
+
+			if users, err := l.Store.Users(ctx, "2"); err != nil || !reflect.DeepEqual(users, []string{"alice", "bob", "carol"}) {
+				t.Errorf("users %v, %v", users, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package AppleTransactions
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// https://developer.apple.com/documentation/appstoreservernotifications/app_store_server_notifications_v1
+
+// v1NotificationTypes - V1 notification_type to the V2 type and subtype reporting the same event.
+var v1NotificationTypes = map[string]notificationKey{
+	"INITIAL_BUY":               {Type: NotificationSubscribed, Subtype: SubtypeInitialBuy},
+	"INTERACTIVE_RENEWAL":       {Type: NotificationSubscribed, Subtype: SubtypeResubscribe},
+	"DID_RENEW":                 {Type: NotificationDidRenew},
+	"DID_RECOVER":               {Type: NotificationDidRenew, Subtype: SubtypeBillingRecovery},
+	"RENEWAL":                   {Type: NotificationDidRenew, Subtype: SubtypeBillingRecovery},
+	"DID_FAIL_TO_RENEW":         {Type: NotificationDidFailToRenew},
+	"DID_CHANGE_RENEWAL_PREF":   {Type: NotificationDidChangeRenewalPref},
+	"DID_CHANGE_RENEWAL_STATUS": {Type: NotificationDidChangeRenewalStatus},
+	"PRICE_INCREASE_CONSENT":    {Type: NotificationPriceIncrease, Subtype: SubtypeAccepted},
+	"CANCEL":                    {Type: NotificationRefund},
+	"REFUND":                    {Type: NotificationRefund},
+	"REVOKE":                    {Type: NotificationRevoke},
+	"CONSUMPTION_REQUEST":       {Type: NotificationConsumptionRequest},
+}
+
+// notificationV1Payload - responseBodyV1, unified_receipt has the same shape as verifyReceipt response.
+type notificationV1Payload struct {
+	NotificationType      string      `json:"notification_type"`
+	Password              string      `json:"password"`
+	Environment           string      `json:"environment"`
+	AutoRenewProductID    string      `json:"auto_renew_product_id"`
+	AutoRenewStatus       string      `json:"auto_renew_status"`
+	BID                   string      `json:"bid"`
+	BVRS                  string      `json:"bvrs"`
+	OriginalTransactionID flexString  `json:"original_transaction_id"`
+	UnifiedReceipt        receiptData `json:"unified_receipt"`
+}
+
+// DecodeV1 - decodes legacy V1 status update notification, password must match SharedSecret.
+func (h *NotificationHandler) DecodeV1(body []byte) (n *Notification, err error) {
+	var payload notificationV1Payload
+
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.Wrap(err, "failed Decode")
+	}
+
+	if h.SharedSecret == "" || subtle.ConstantTimeCompare([]byte(payload.Password), []byte(h.SharedSecret)) != 1 {
+		return nil, errors.New("password mismatch")
+	}
+
+	if h.BundleID != "" && payload.BID != h.BundleID {
+		return nil, errors.Errorf("unexpected bid %q", payload.BID)
+	}
+
+	key, ok := v1NotificationTypes[payload.NotificationType]
+	if !ok {
+		key = notificationKey{Type: NotificationType(payload.NotificationType)}
+	}
+
+	if key.Type == NotificationDidChangeRenewalStatus {
+		key.Subtype = SubtypeAutoRenewDisabled
+		if payload.AutoRenewStatus == "true" {
+			key.Subtype = SubtypeAutoRenewEnabled
+		}
+	}
+
+	n = &Notification{
+		Type:        key.Type,
+		Subtype:     key.Subtype,
+		Version:     "1.0",
+		Environment: v1Environment(payload.Environment),
+		BundleID:    payload.BID,
+	}
+
+	transactions, err := payload.UnifiedReceipt.collectTransactions()
+	if err != nil {
+		return nil, errors.Wrap(err, "collectTransactions fail")
+	}
+
+	var originalID = string(payload.OriginalTransactionID)
+
+	for i, v := range transactions {
+		if originalID != "" && v.OriginalID != originalID {
+			continue
+		}
+
+		if n.Transaction == nil || v.SubscriptionExpireAt > n.Transaction.SubscriptionExpireAt {
+			n.Transaction = &transactions[i]
+		}
+	}
+
+	if n.Transaction != nil {
+		originalID = n.Transaction.OriginalID
+	}
+
+	for _, v := range payload.UnifiedReceipt.PendingRenewalInfo {
+		if v.OriginalTransactionID == originalID {
+			ri, err := v.renewalInfo()
+			if err != nil {
+				return nil, errors.Wrap(err, "renewalInfo fail")
+			}
+
+			n.RenewalInfo = &ri
+			break
+		}
+	}
+
+	if n.Transaction != nil && n.RenewalInfo != nil {
+		n.Transaction.AccessExpireAt = n.Transaction.AccessExpiry(n.RenewalInfo)
+	}
+
+	return n, nil
+}
+
+// v1Environment - V1 sends "PROD" where V2 sends "Production".
+func v1Environment(env string) string {
+	if env == "PROD" {
+		return "Production"
+	}
+
+	return env
+}
+
+func (i *pendingRenewalInfo) renewalInfo() (res RenewalInfo, err error) {
+	intent, _ := strconv.Atoi(i.ExpirationIntent)
+
+	res = RenewalInfo{
+		OriginalTransactionID:  i.OriginalTransactionID,
+		ProductID:              i.ProductID,
+		AutoRenewProductID:     i.AutoRenewProductID,
+		AutoRenewStatus:        i.AutoRenewStatus == "1",
+		ExpirationIntent:       intent,
+		IsInBillingRetryPeriod: i.IsInBillingRetryPeriod == "1",
+	}
+
+	if res.GracePeriodExpiresAt, err = unixFromMS(i.GracePeriodExpiresMS); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// flexString - V1 sends some identifiers as json numbers and some as strings.
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	*s = flexString(strings.Trim(string(data), `"`))
+	if *s == "null" {
+		*s = ""
+	}
+
+	return nil
+}
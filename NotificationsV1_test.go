@@ -0,0 +1,53 @@
+package AppleTransactions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeV1(t *testing.T) {
+	const body = `{"notification_type":"DID_CHANGE_RENEWAL_STATUS","password":"%s","environment":"PROD",
+		"auto_renew_status":"false","bid":"%s","original_transaction_id":1000,
+		"unified_receipt":{"status":0,"latest_receipt_info":[
+			{"product_id":"monthly","transaction_id":"1001","original_transaction_id":"1000","expires_date_ms":"2000000000000"},
+			{"product_id":"monthly","transaction_id":"1002","original_transaction_id":"1000","expires_date_ms":"2100000000000"},
+			{"product_id":"yearly","transaction_id":"2001","original_transaction_id":"2000","expires_date_ms":"2200000000000"}],
+		"pending_renewal_info":[{"original_transaction_id":"1000","product_id":"monthly","auto_renew_status":"0"}]}}`
+
+	var h = &NotificationHandler{SharedSecret: "secret", BundleID: "com.example.app"}
+
+	payload := func(password, bid string) []byte {
+		return []byte(fmt.Sprintf(body, password, bid))
+	}
+
+	n, err := h.DecodeV1(payload("secret", "com.example.app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.Type != NotificationDidChangeRenewalStatus || n.Subtype != SubtypeAutoRenewDisabled || n.Environment != "Production" {
+		t.Errorf("decoded %s/%s in %s", n.Type, n.Subtype, n.Environment)
+	}
+
+	if n.Transaction == nil || n.Transaction.ID != "1002" || n.RenewalInfo == nil || n.RenewalInfo.OriginalTransactionID != "1000" {
+		t.Errorf("decoded transaction %v, renewal info %v", n.Transaction, n.RenewalInfo)
+	}
+
+	for name, c := range map[string]struct {
+		h    *NotificationHandler
+		body []byte
+		want string
+	}{
+		"wrong password":   {h, payload("guess", "com.example.app"), "password mismatch"},
+		"empty password":   {h, payload("", "com.example.app"), "password mismatch"},
+		"no shared secret": {&NotificationHandler{}, payload("", "com.example.app"), "password mismatch"},
+		"other app":        {h, payload("secret", "com.example.other"), "unexpected bid"},
+		"prefix of secret": {h, payload("secre", "com.example.app"), "password mismatch"},
+		"secret with tail": {h, payload("secret2", "com.example.app"), "password mismatch"},
+	} {
+		if _, err = c.h.DecodeV1(c.body); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package AppleTransactions
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+
+// NotificationType - App Store server notification type.
+type NotificationType string
+
+const (
+	NotificationConsumptionRequest     NotificationType = "CONSUMPTION_REQUEST"
+	NotificationDidChangeRenewalPref   NotificationType = "DID_CHANGE_RENEWAL_PREF"
+	NotificationDidChangeRenewalStatus NotificationType = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationDidFailToRenew         NotificationType = "DID_FAIL_TO_RENEW"
+	NotificationDidRenew               NotificationType = "DID_RENEW"
+	NotificationExpired                NotificationType = "EXPIRED"
+	NotificationExternalPurchaseToken  NotificationType = "EXTERNAL_PURCHASE_TOKEN"
+	NotificationGracePeriodExpired     NotificationType = "GRACE_PERIOD_EXPIRED"
+	NotificationOfferRedeemed          NotificationType = "OFFER_REDEEMED"
+	NotificationOneTimeCharge          NotificationType = "ONE_TIME_CHARGE"
+	NotificationPriceIncrease          NotificationType = "PRICE_INCREASE"
+	NotificationRefund                 NotificationType = "REFUND"
+	NotificationRefundDeclined         NotificationType = "REFUND_DECLINED"
+	NotificationRefundReversed         NotificationType = "REFUND_REVERSED"
+	NotificationRenewalExtended        NotificationType = "RENEWAL_EXTENDED"
+	NotificationRenewalExtension       NotificationType = "RENEWAL_EXTENSION"
+	NotificationRevoke                 NotificationType = "REVOKE"
+	NotificationSubscribed             NotificationType = "SUBSCRIBED"
+	NotificationTest                   NotificationType = "TEST"
+)
+
+// NotificationSubtype - details of NotificationType, empty if Apple sends none.
+type NotificationSubtype string
+
+const (
+	SubtypeInitialBuy        NotificationSubtype = "INITIAL_BUY"
+	SubtypeResubscribe       NotificationSubtype = "RESUBSCRIBE"
+	SubtypeDowngrade         NotificationSubtype = "DOWNGRADE"
+	SubtypeUpgrade           NotificationSubtype = "UPGRADE"
+	SubtypeAutoRenewEnabled  NotificationSubtype = "AUTO_RENEW_ENABLED"
+	SubtypeAutoRenewDisabled NotificationSubtype = "AUTO_RENEW_DISABLED"
+	SubtypeVoluntary         NotificationSubtype = "VOLUNTARY"
+	SubtypeBillingRetry      NotificationSubtype = "BILLING_RETRY"
+	SubtypePriceIncrease     NotificationSubtype = "PRICE_INCREASE"
+	SubtypeGracePeriod       NotificationSubtype = "GRACE_PERIOD"
+	SubtypeBillingRecovery   NotificationSubtype = "BILLING_RECOVERY"
+	SubtypePending           NotificationSubtype = "PENDING"
+	SubtypeAccepted          NotificationSubtype = "ACCEPTED"
+	SubtypeProductNotForSale NotificationSubtype = "PRODUCT_NOT_FOR_SALE"
+	SubtypeSummary           NotificationSubtype = "SUMMARY"
+	SubtypeFailure           NotificationSubtype = "FAILURE"
+	SubtypeUnreported        NotificationSubtype = "UNREPORTED"
+)
+
+// Notification - decoded and verified server notification.
+type Notification struct {
+	Type    NotificationType
+	Subtype NotificationSubtype
+	UUID    string
+	Version string
+
+	// SignedDateMS - Unix milliseconds when Apple signed the notification.
+	SignedDateMS int64
+
+	Environment string
+	BundleID    string
+	AppAppleID  int64
+
+	// Transaction - nil if the notification carries no transaction.
+	Transaction *Transaction
+	// RenewalInfo - nil if the notification carries no renewal info.
+	RenewalInfo *RenewalInfo
+}
+
+// RenewalInfo - subscription renewal info from apple.
+type RenewalInfo struct {
+	OriginalTransactionID  string
+	ProductID              string
+	AutoRenewProductID     string
+	AutoRenewStatus        bool
+	ExpirationIntent       int
+	IsInBillingRetryPeriod bool
+
+	// GracePeriodExpiresAt - Unix timestamp, 0 if billing grace period is off.
+	GracePeriodExpiresAt int64
+}
+
+// NotificationFunc - callback for a notification, returned error makes Apple retry.
+type NotificationFunc func(ctx context.Context, n *Notification) error
+
+// NotificationHandler - http.Handler for App Store Server Notifications V2 and legacy V1.
+//
+// Responds 200 when notification is processed or nobody is interested in it,
+// 400 when request can't be decoded or verified and 500 when callback fails,
+// so Apple retries delivery later.
+type NotificationHandler struct {
+	Verifier *JWSVerifier
+
+	// BundleID - when set, notifications for other apps are rejected.
+	BundleID string
+
+	// SharedSecret - app shared secret, V1 notifications are rejected if empty.
+	SharedSecret string
+
+	// Default - called for notifications without registered callback, may be nil.
+	Default NotificationFunc
+
+	// Dedup - when set, duplicates and out of order notifications are not dispatched, see Process.
+	Dedup DedupStore
+
+	// ClaimLease - how long an unfinished claim of a notification blocks its retries, 10 minutes if 0.
+	// Claims of processes which crashed mid callback are taken over after it, keep it above callback duration.
+	ClaimLease time.Duration
+
+	// Stale - called for subscription state older than already processed notifications, may be nil.
+	Stale NotificationFunc
+
+	// Store - when set, notifications and their transactions are written to it before dispatch.
+	Store Store
+
+	callbacks map[notificationKey]NotificationFunc
+}
+
+type notificationKey struct {
+	Type    NotificationType
+	Subtype NotificationSubtype
+}
+
+// maxNotificationSize - Apple payloads are a few kilobytes.
+const maxNotificationSize = 1 << 20
+
+// NewNotificationHandler - handler verifying notifications with v.
+func NewNotificationHandler(v *JWSVerifier) *NotificationHandler {
+	return &NotificationHandler{
+		Verifier:  v,
+		callbacks: make(map[notificationKey]NotificationFunc),
+	}
+}
+
+// On - registers fn for notification type t with any subtype.
+//
+// Not safe to call concurrently with ServeHTTP.
+func (h *NotificationHandler) On(t NotificationType, fn NotificationFunc) {
+	h.OnSubtype(t, "", fn)
+}
+
+// OnSubtype - registers fn for notification type t with subtype s, takes precedence over On.
+func (h *NotificationHandler) OnSubtype(t NotificationType, s NotificationSubtype, fn NotificationFunc) {
+	if h.callbacks == nil {
+		h.callbacks = make(map[notificationKey]NotificationFunc)
+	}
+
+	h.callbacks[notificationKey{Type: t, Subtype: s}] = fn
+}
+
+func (h *NotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationSize))
+	if err != nil {
+		http.Error(w, "malformed body", http.StatusBadRequest)
+		return
+	}
+
+	var body notificationBody
+
+	if err = json.Unmarshal(raw, &body); err != nil {
+		http.Error(w, "malformed body", http.StatusBadRequest)
+		return
+	}
+
+	var n *Notification
+
+	if body.SignedPayload == "" && body.NotificationType != "" {
+		n, err = h.DecodeV1(raw)
+	} else {
+		n, err = h.Decode(body.SignedPayload)
+	}
+
+	if err != nil {
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.Process(r.Context(), n); err != nil {
+		http.Error(w, "notification not processed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Decode - verifies signedPayload and nested transaction and renewal info.
+//
+// Notifications and transactions of other apps or environments than set in Verifier are rejected.
+func (h *NotificationHandler) Decode(signedPayload string) (n *Notification, err error) {
+	var payload notificationV2Payload
+
+	if err = h.Verifier.Verify(signedPayload, &payload); err != nil {
+		return nil, errors.Wrap(err, "verify signedPayload")
+	}
+
+	if h.BundleID != "" && payload.Data.BundleID != h.BundleID {
+		return nil, errors.Errorf("unexpected bundleId %q", payload.Data.BundleID)
+	}
+
+	if err = h.Verifier.checkApp(payload.Data.BundleID, payload.Data.Environment); err != nil {
+		return nil, err
+	}
+
+	n = &Notification{
+		Type:         payload.NotificationType,
+		Subtype:      payload.Subtype,
+		UUID:         payload.NotificationUUID,
+		Version:      payload.Version,
+		SignedDateMS: payload.SignedDate,
+		Environment:  payload.Data.Environment,
+		BundleID:     payload.Data.BundleID,
+		AppAppleID:   payload.Data.AppAppleID,
+	}
+
+	if payload.Data.SignedTransactionInfo != "" {
+		var tx signedTransaction
+
+		if err = h.Verifier.Verify(payload.Data.SignedTransactionInfo, &tx); err != nil {
+			return nil, errors.Wrap(err, "verify signedTransactionInfo")
+		}
+
+		if err = h.Verifier.checkApp(tx.BundleID, tx.Environment); err != nil {
+			return nil, errors.Wrap(err, "signedTransactionInfo")
+		}
+
+		t := tx.transaction()
+		n.Transaction = &t
+	}
+
+	if payload.Data.SignedRenewalInfo != "" {
+		var info signedRenewalInfo
+
+		if err = h.Verifier.Verify(payload.Data.SignedRenewalInfo, &info); err != nil {
+			return nil, errors.Wrap(err, "verify signedRenewalInfo")
+		}
+
+		ri := info.renewalInfo()
+		n.RenewalInfo = &ri
+	}
+
+	if n.Transaction != nil && n.RenewalInfo != nil {
+		n.Transaction.AccessExpireAt = n.Transaction.AccessExpiry(n.RenewalInfo)
+	}
+
+	// family members lose access when REVOKE arrives, even if transaction has no revocationDate
+	if n.FamilyAccessRevoked() && n.Transaction.RevokedAt == 0 {
+		n.Transaction.revoke(msToUnix(n.SignedDateMS))
+	}
+
+	return n, nil
+}
+
+// Dispatch - calls callback registered for n.
+func (h *NotificationHandler) Dispatch(ctx context.Context, n *Notification) error {
+	fn, ok := h.callbacks[notificationKey{Type: n.Type, Subtype: n.Subtype}]
+	if !ok {
+		fn, ok = h.callbacks[notificationKey{Type: n.Type}]
+	}
+
+	if !ok {
+		fn = h.Default
+	}
+
+	if fn == nil {
+		return nil
+	}
+
+	return fn(ctx, n)
+}
+
+// notificationBody - V2 sends signedPayload, V1 sends notification_type at top level.
+type notificationBody struct {
+	SignedPayload    string `json:"signedPayload"`
+	NotificationType string `json:"notification_type"`
+}
+
+type notificationV2Payload struct {
+	NotificationType NotificationType    `json:"notificationType"`
+	Subtype          NotificationSubtype `json:"subtype"`
+	NotificationUUID string              `json:"notificationUUID"`
+	Version          string              `json:"version"`
+	SignedDate       int64               `json:"signedDate"`
+	Data             struct {
+		AppAppleID            int64  `json:"appAppleId"`
+		BundleID              string `json:"bundleId"`
+		BundleVersion         string `json:"bundleVersion"`
+		Environment           string `json:"environment"`
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+		SignedRenewalInfo     string `json:"signedRenewalInfo"`
+		Status                int    `json:"status"`
+	} `json:"data"`
+}
+
+// offerTypeIntroductory - offerType of introductory offers, StoreKit 2 doesn't tell free trials apart.
+const offerTypeIntroductory = 1
+
+// signedTransaction - JWSTransactionDecodedPayload.
+type signedTransaction struct {
+	TransactionID               string `json:"transactionId"`
+	OriginalTransactionID       string `json:"originalTransactionId"`
+	WebOrderLineItemID          string `json:"webOrderLineItemId"`
+	BundleID                    string `json:"bundleId"`
+	ProductID                   string `json:"productId"`
+	SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier"`
+	PurchaseDate                int64  `json:"purchaseDate"`
+	OriginalPurchaseDate        int64  `json:"originalPurchaseDate"`
+	ExpiresDate                 int64  `json:"expiresDate"`
+	Quantity                    int    `json:"quantity"`
+	Type                        string `json:"type"`
+	AppAccountToken             string `json:"appAccountToken"`
+	InAppOwnershipType          string `json:"inAppOwnershipType"`
+	SignedDate                  int64  `json:"signedDate"`
+	RevocationReason            *int   `json:"revocationReason"`
+	RevocationDate              int64  `json:"revocationDate"`
+	IsUpgraded                  bool   `json:"isUpgraded"`
+	OfferType                   int    `json:"offerType"`
+	OfferIdentifier             string `json:"offerIdentifier"`
+	Environment                 string `json:"environment"`
+	Storefront                  string `json:"storefront"`
+	TransactionReason           string `json:"transactionReason"`
+}
+
+// signedRenewalInfo - JWSRenewalInfoDecodedPayload.
+type signedRenewalInfo struct {
+	ExpirationIntent       int    `json:"expirationIntent"`
+	OriginalTransactionID  string `json:"originalTransactionId"`
+	AutoRenewProductID     string `json:"autoRenewProductId"`
+	ProductID              string `json:"productId"`
+	AutoRenewStatus        int    `json:"autoRenewStatus"`
+	IsInBillingRetryPeriod bool   `json:"isInBillingRetryPeriod"`
+	PriceIncreaseStatus    int    `json:"priceIncreaseStatus"`
+	GracePeriodExpiresDate int64  `json:"gracePeriodExpiresDate"`
+	OfferType              int    `json:"offerType"`
+	OfferIdentifier        string `json:"offerIdentifier"`
+	SignedDate             int64  `json:"signedDate"`
+	Environment            string `json:"environment"`
+	RenewalDate            int64  `json:"renewalDate"`
+}
+
+func (t *signedTransaction) transaction() (res Transaction) {
+	var expires int64
+
+	if t.ExpiresDate != 0 {
+		expires = msToUnix(t.ExpiresDate)
+	}
+
+	res = Transaction{
+		ID:                   t.TransactionID,
+		OriginalID:           t.OriginalTransactionID,
+		InAppName:            t.ProductID,
+		Quantity:             t.Quantity,
+		SubscriptionExpireAt: expires,
+		AccessExpireAt:       expires,
+		PurchasedAt:          msToUnix(t.PurchaseDate),
+		SubscriptionGroup:    t.SubscriptionGroupIdentifier,
+		IsInIntroOfferPeriod: t.OfferType == offerTypeIntroductory,
+		IsUpgraded:           t.IsUpgraded,
+		OwnershipType:        OwnershipType(t.InAppOwnershipType),
+		ProductType:          ProductType(t.Type),
+		AppAccountToken:      strings.ToLower(t.AppAccountToken),
+	}
+
+	if t.RevocationDate != 0 {
+		res.revoke(msToUnix(t.RevocationDate))
+	}
+
+	return res
+}
+
+func (i *signedRenewalInfo) renewalInfo() RenewalInfo {
+	return RenewalInfo{
+		OriginalTransactionID:  i.OriginalTransactionID,
+		ProductID:              i.ProductID,
+		AutoRenewProductID:     i.AutoRenewProductID,
+		AutoRenewStatus:        i.AutoRenewStatus == 1,
+		ExpirationIntent:       i.ExpirationIntent,
+		IsInBillingRetryPeriod: i.IsInBillingRetryPeriod,
+		GracePeriodExpiresAt:   msToUnix(i.GracePeriodExpiresDate),
+	}
+}
@@ -0,0 +1,138 @@
+package AppleTransactions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testNotification - signedPayload of a DID_RENEW notification with a signed transaction.
+func testNotification(t *testing.T, s *testSigner, uuid, bundleID, environment string, tx signedTransaction) string {
+	t.Helper()
+
+	var payload notificationV2Payload
+
+	payload.NotificationType = NotificationDidRenew
+	payload.NotificationUUID = uuid
+	payload.Version = "2.0"
+	payload.SignedDate = time.Now().UnixMilli()
+	payload.Data.BundleID = bundleID
+	payload.Data.Environment = environment
+	payload.Data.SignedTransactionInfo = s.sign(t, tx)
+
+	return s.sign(t, payload)
+}
+
+func testSignedTransaction(bundleID, environment string) signedTransaction {
+	return signedTransaction{
+		TransactionID:         "2000000000000001",
+		OriginalTransactionID: "2000000000000001",
+		BundleID:              bundleID,
+		ProductID:             "com.example.app.monthly",
+		PurchaseDate:          time.Now().UnixMilli(),
+		ExpiresDate:           time.Now().Add(time.Hour).UnixMilli(),
+		Quantity:              1,
+		Environment:           environment,
+	}
+}
+
+func TestDecodeApp(t *testing.T) {
+	v, s := newTestJWS(t)
+	v.BundleID, v.Environment = "com.example.app", "Production"
+
+	var h = NewNotificationHandler(v)
+
+	n, err := h.Decode(testNotification(t, s, "1", "com.example.app", "Production", testSignedTransaction("com.example.app", "Production")))
+	if err != nil || n.Transaction == nil {
+		t.Fatalf("matching notification: %v, %v", n, err)
+	}
+
+	for name, c := range map[string]struct {
+		payload string
+		want    string
+	}{
+		"sandbox notification": {
+			testNotification(t, s, "2", "com.example.app", "Sandbox", testSignedTransaction("com.example.app", "Sandbox")),
+			"unexpected environment",
+		},
+		"other app notification": {
+			testNotification(t, s, "3", "com.example.other", "Production", testSignedTransaction("com.example.app", "Production")),
+			"unexpected bundleId",
+		},
+		"sandbox transaction": {
+			testNotification(t, s, "4", "com.example.app", "Production", testSignedTransaction("com.example.app", "Sandbox")),
+			"signedTransactionInfo: unexpected environment",
+		},
+		"other app transaction": {
+			testNotification(t, s, "5", "com.example.app", "Production", testSignedTransaction("com.example.other", "Production")),
+			"signedTransactionInfo: unexpected bundleId",
+		},
+	} {
+		if _, err = h.Decode(c.payload); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+}
+
+func TestServeHTTPStatus(t *testing.T) {
+	v, s := newTestJWS(t)
+	_, stranger := newTestJWS(t)
+
+	var (
+		calls int
+		fail  error
+		h     = NewNotificationHandler(v)
+		tx    = testSignedTransaction("com.example.app", "Production")
+	)
+
+	h.Dedup = NewMemoryDedupStore()
+	h.On(NotificationDidRenew, func(context.Context, *Notification) error {
+		calls++
+		return fail
+	})
+
+	post := func(body string) int {
+		t.Helper()
+
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/apple", strings.NewReader(body)))
+
+		return w.Code
+	}
+
+	var signed = `{"signedPayload":"` + testNotification(t, s, "1", "com.example.app", "Production", tx) + `"}`
+
+	for _, c := range []struct {
+		name  string
+		body  string
+		fail  error
+		want  int
+		calls int
+	}{
+		{"malformed body", `{"signedPayload":`, nil, http.StatusBadRequest, 0},
+		{"foreign signature", `{"signedPayload":"` + testNotification(t, stranger, "2", "com.example.app", "Production", tx) + `"}`,
+			nil, http.StatusBadRequest, 0},
+		{"callback failure", signed, errors.New("database down"), http.StatusInternalServerError, 1},
+		{"retry", signed, nil, http.StatusOK, 2},
+		{"duplicate", signed, nil, http.StatusOK, 2},
+		{"v1 without shared secret", `{"notification_type":"DID_RENEW","password":""}`, nil, http.StatusBadRequest, 2},
+	} {
+		fail = c.fail
+
+		if code := post(c.body); code != c.want || calls != c.calls {
+			t.Errorf("%s: %d with %d calls, want %d with %d", c.name, code, calls, c.want, c.calls)
+		}
+	}
+
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/apple", nil))
+
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
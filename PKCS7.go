@@ -0,0 +1,312 @@
+package AppleTransactions
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"github.com/pkg/errors"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc2315
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     asn1.RawValue
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// parsePKCS7 - signed data and its content from BER or DER encoded container.
+func parsePKCS7(data []byte) (sd pkcs7SignedData, content []byte, err error) {
+	der, rest, err := berToDER(data)
+	if err != nil {
+		return sd, nil, errors.Wrap(err, "berToDER fail")
+	}
+
+	if len(rest) != 0 {
+		return sd, nil, errors.New("trailing data after pkcs7")
+	}
+
+	var info pkcs7ContentInfo
+
+	if _, err = asn1.Unmarshal(der, &info); err != nil {
+		return sd, nil, errors.Wrap(err, "failed Unmarshal ContentInfo")
+	}
+
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return sd, nil, errors.New("not a pkcs7 signed data")
+	}
+
+	if _, err = asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return sd, nil, errors.Wrap(err, "failed Unmarshal SignedData")
+	}
+
+	if !sd.ContentInfo.ContentType.Equal(oidPKCS7Data) {
+		return sd, nil, errors.New("unexpected content type")
+	}
+
+	if _, err = asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return sd, nil, errors.Wrap(err, "failed Unmarshal content")
+	}
+
+	return sd, content, nil
+}
+
+// Limits of berToDER, receipts are tens of kilobytes and a few levels deep but come from untrusted clients.
+const (
+	maxBERSize  = 4 << 20
+	maxBERDepth = 32
+)
+
+// berToDER - converts first BER element of data to DER, returns rest of data.
+//
+// Apple receipts use indefinite lengths and constructed octet strings which encoding/asn1 rejects.
+// Content lengths are measured in one pass and DER is written in another, so nothing is copied twice.
+func berToDER(data []byte) (der, rest []byte, err error) {
+	if len(data) > maxBERSize {
+		return nil, nil, errors.Errorf("element exceeds %d bytes", maxBERSize)
+	}
+
+	var c berConverter
+
+	_, size, rest, err := c.measure(data, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, _ = c.write(make([]byte, 0, size), data, false)
+
+	return der, rest, nil
+}
+
+// berConverter - DER content lengths of constructed elements in order they are met.
+type berConverter struct {
+	sizes []int
+	next  int
+}
+
+// measure - DER content and element length of first element of data, records sizes of constructed elements.
+func (c *berConverter) measure(data []byte, depth int) (content, element int, rest []byte, err error) {
+	if depth > maxBERDepth {
+		return 0, 0, nil, errors.New("nesting too deep")
+	}
+
+	h, err := readBERHeader(data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	if !h.constructed {
+		content = len(h.body)
+		return content, len(h.identifier) + lengthSize(content) + content, h.rest, nil
+	}
+
+	var (
+		i        = len(c.sizes)
+		children = h.body
+	)
+
+	c.sizes = append(c.sizes, 0)
+
+	for {
+		if h.indefinite && len(children) >= 2 && children[0] == 0 && children[1] == 0 {
+			h.rest = children[2:]
+			break
+		}
+
+		if !h.indefinite && len(children) == 0 {
+			break
+		}
+
+		childContent, childElement, next, err := c.measure(children, depth+1)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		// constructed octet string becomes primitive one with concatenated content
+		if h.octets() {
+			content += childContent
+		} else {
+			content += childElement
+		}
+
+		children = next
+	}
+
+	c.sizes[i] = content
+
+	var identifier = len(h.identifier)
+	if h.octets() {
+		identifier = 1
+	}
+
+	return content, identifier + lengthSize(content) + content, h.rest, nil
+}
+
+// write - appends DER of first element of measured data to out, only its content if contentOnly.
+func (c *berConverter) write(out, data []byte, contentOnly bool) ([]byte, []byte) {
+	// data was measured, so headers are valid
+	h, _ := readBERHeader(data)
+
+	if !h.constructed {
+		if !contentOnly {
+			out = appendHeader(out, h.identifier, len(h.body))
+		}
+
+		return append(out, h.body...), h.rest
+	}
+
+	var size = c.sizes[c.next]
+	c.next++
+
+	if !contentOnly {
+		var identifier = h.identifier
+		if h.octets() {
+			identifier = []byte{0x04}
+		}
+
+		out = appendHeader(out, identifier, size)
+	}
+
+	var children = h.body
+
+	for {
+		if h.indefinite && len(children) >= 2 && children[0] == 0 && children[1] == 0 {
+			return out, children[2:]
+		}
+
+		if !h.indefinite && len(children) == 0 {
+			return out, h.rest
+		}
+
+		out, children = c.write(out, children, h.octets())
+	}
+}
+
+// berHeader - identifier and length octets of an element.
+type berHeader struct {
+	identifier  []byte
+	constructed bool
+	indefinite  bool
+
+	// body - content of element, rest of data if length is indefinite.
+	body []byte
+	// rest - data after element, unknown until end of contents if length is indefinite.
+	rest []byte
+}
+
+func (h berHeader) octets() bool {
+	return h.identifier[0] == 0x24
+}
+
+func readBERHeader(data []byte) (h berHeader, err error) {
+	if len(data) < 2 {
+		return h, errors.New("truncated element")
+	}
+
+	// identifier octets
+	var offset = 1
+
+	if data[0]&0x1f == 0x1f {
+		for offset < len(data) && data[offset]&0x80 != 0 {
+			offset++
+		}
+		offset++
+	}
+
+	if offset >= len(data) {
+		return h, errors.New("truncated tag")
+	}
+
+	h.identifier = data[:offset]
+	h.constructed = data[0]&0x20 != 0
+	h.indefinite = data[offset] == 0x80
+
+	var length int
+
+	// length octets
+	switch {
+	case h.indefinite:
+		if !h.constructed {
+			return h, errors.New("indefinite length of primitive element")
+		}
+
+		h.body = data[offset+1:]
+
+		return h, nil
+	case data[offset]&0x80 == 0:
+		length = int(data[offset])
+		offset++
+	default:
+		n := int(data[offset] & 0x7f)
+		offset++
+
+		if n > 4 || offset+n > len(data) {
+			return h, errors.New("invalid length")
+		}
+
+		for _, b := range data[offset : offset+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+
+	if length < 0 || offset+length > len(data) {
+		return h, errors.New("length exceeds data")
+	}
+
+	h.body, h.rest = data[offset:offset+length], data[offset+length:]
+
+	return h, nil
+}
+
+// appendHeader - identifier and definite length n.
+func appendHeader(out, identifier []byte, n int) []byte {
+	out = append(out, identifier...)
+
+	if n < 0x80 {
+		return append(out, byte(n))
+	}
+
+	out = append(out, 0x80|byte(lengthSize(n)-1))
+
+	for shift := (lengthSize(n) - 2) * 8; shift >= 0; shift -= 8 {
+		out = append(out, byte(n>>shift))
+	}
+
+	return out
+}
+
+// lengthSize - length octets of definite length n.
+func lengthSize(n int) int {
+	var size = 1
+
+	if n >= 0x80 {
+		for ; n > 0; n >>= 8 {
+			size++
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,63 @@
+package AppleTransactions
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestBERToDER(t *testing.T) {
+	// SEQUENCE of indefinite length holding constructed OCTET STRING and INTEGER, followed by a byte
+	var in = []byte{
+		0x30, 0x80,
+		0x24, 0x80, 0x04, 0x02, 1, 2, 0x24, 0x04, 0x04, 0x02, 3, 4, 0, 0,
+		0x02, 0x01, 5,
+		0, 0,
+		9,
+	}
+
+	der, rest, err := berToDER(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hex.EncodeToString(der); got != "3009040401020304020105" || !bytes.Equal(rest, []byte{9}) {
+		t.Errorf("der %s rest %x", got, rest)
+	}
+
+	// long definite length is kept in its shortest form
+	var long = append([]byte{0x04, 0x82, 0x01, 0x00}, make([]byte, 256)...)
+
+	if der, _, err = berToDER(long); err != nil || !bytes.Equal(der, long) {
+		t.Errorf("long octet string: %x..., %v", der[:4], err)
+	}
+}
+
+func TestBERToDERLimits(t *testing.T) {
+	nested := func(depth int) []byte {
+		var b = bytes.Repeat([]byte{0x30, 0x80}, depth)
+		b = append(b, 0x05, 0x00)
+		return append(b, make([]byte, 2*depth)...)
+	}
+
+	if _, _, err := berToDER(nested(maxBERDepth)); err != nil {
+		t.Errorf("depth %d: %v", maxBERDepth, err)
+	}
+
+	var started = time.Now()
+
+	for _, in := range [][]byte{
+		nested(maxBERDepth + 1),
+		bytes.Repeat([]byte{0x30, 0x80}, 160<<10),
+		make([]byte, maxBERSize+1),
+	} {
+		if _, _, err := berToDER(in); err == nil {
+			t.Errorf("%d bytes accepted", len(in))
+		}
+	}
+
+	if took := time.Since(started); took > time.Second {
+		t.Errorf("rejecting took %s", took)
+	}
+}
@@ -0,0 +1,114 @@
+package AppleTransactions
+
+import (
+	"sort"
+)
+
+// PlanChangeKind - direction of a change between products of one subscription group.
+type PlanChangeKind string
+
+const (
+	// PlanUpgrade - to a higher service level, Apple applies it immediately.
+	PlanUpgrade PlanChangeKind = "upgrade"
+	// PlanDowngrade - to a lower service level, Apple applies it at next renewal.
+	PlanDowngrade PlanChangeKind = "downgrade"
+	// PlanCrossgrade - to the same or unknown service level.
+	PlanCrossgrade PlanChangeKind = "crossgrade"
+)
+
+// PlanChange - subscription switched or will switch from one product to another.
+type PlanChange struct {
+	Kind       PlanChangeKind
+	OriginalID string
+
+	FromProduct string
+	ToProduct   string
+
+	// EffectiveAt - Unix timestamp when ToProduct takes over.
+	EffectiveAt int64
+
+	// Pending - change is scheduled for next renewal by auto_renew_product_id.
+	Pending bool
+}
+
+// PlanLevels - product ID to service level within its subscription group,
+// 1 is the highest level, as in App Store Connect.
+type PlanLevels map[string]int
+
+// Changes - plan changes found in history and pending in renewals, ordered by EffectiveAt.
+//
+// Apple keeps original transaction ID across changes within a subscription group,
+// so history is walked per original transaction.
+func (l PlanLevels) Changes(history []Transaction, renewals []RenewalInfo) (res []PlanChange) {
+	var chains = make(map[string][]Transaction)
+
+	for _, t := range history {
+		if t.SubscriptionExpireAt != 0 {
+			chains[t.OriginalID] = append(chains[t.OriginalID], t)
+		}
+	}
+
+	for originalID, chain := range chains {
+		sort.Slice(chain, func(i, j int) bool { return chain[i].PurchasedAt < chain[j].PurchasedAt })
+
+		for i := 1; i < len(chain); i++ {
+			prev, cur := chain[i-1], chain[i]
+			if prev.InAppName == cur.InAppName {
+				continue
+			}
+
+			var kind = l.kind(prev.InAppName, cur.InAppName)
+			if prev.IsUpgraded {
+				kind = PlanUpgrade
+			}
+
+			res = append(res, PlanChange{
+				Kind:        kind,
+				OriginalID:  originalID,
+				FromProduct: prev.InAppName,
+				ToProduct:   cur.InAppName,
+				EffectiveAt: cur.PurchasedAt,
+			})
+		}
+	}
+
+	for _, r := range renewals {
+		if r.AutoRenewProductID == "" || r.AutoRenewProductID == r.ProductID {
+			continue
+		}
+
+		var change = PlanChange{
+			Kind:        l.kind(r.ProductID, r.AutoRenewProductID),
+			OriginalID:  r.OriginalTransactionID,
+			FromProduct: r.ProductID,
+			ToProduct:   r.AutoRenewProductID,
+			Pending:     true,
+		}
+
+		for _, t := range chains[r.OriginalTransactionID] {
+			if t.SubscriptionExpireAt > change.EffectiveAt {
+				change.EffectiveAt = t.SubscriptionExpireAt
+			}
+		}
+
+		res = append(res, change)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].EffectiveAt < res[j].EffectiveAt })
+
+	return res
+}
+
+func (l PlanLevels) kind(from, to string) PlanChangeKind {
+	fromLevel, okFrom := l[from]
+	toLevel, okTo := l[to]
+
+	switch {
+	case !okFrom || !okTo || fromLevel == toLevel:
+		return PlanCrossgrade
+	case toLevel < fromLevel:
+		return PlanUpgrade
+	default:
+		return PlanDowngrade
+	}
+}
@@ -0,0 +1,136 @@
+package AppleTransactions
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://developer.apple.com/documentation/storekit/in-app_purchase/original_api_for_in-app_purchase/subscriptions_and_offers/generating_a_signature_for_promotional_offers
+
+// promotionalOfferSeparator - INVISIBLE SEPARATOR joining signed values.
+const promotionalOfferSeparator = "\u2063"
+
+// PromotionalOffer - values StoreKit needs to apply a subscription promotional offer.
+type PromotionalOffer struct {
+	KeyID           string
+	ProductID       string
+	OfferID         string
+	AppAccountToken string
+	Nonce           string
+
+	// Timestamp - Unix milliseconds.
+	Timestamp int64
+
+	// Signature - base64 DER encoded ECDSA signature.
+	Signature string
+}
+
+// PromotionalOfferSigner - signs promotional offers with in-app purchase key from App Store Connect.
+type PromotionalOfferSigner struct {
+	BundleID string
+	KeyID    string
+	Key      *ecdsa.PrivateKey
+
+	// Now - clock for offer timestamp, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewPromotionalOfferSigner - signer with .p8 key downloaded from App Store Connect.
+func NewPromotionalOfferSigner(bundleID, keyID string, p8 []byte) (*PromotionalOfferSigner, error) {
+	key, err := ParsePrivateKey(p8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PromotionalOfferSigner{BundleID: bundleID, KeyID: keyID, Key: key}, nil
+}
+
+// ParsePrivateKey - ECDSA key of PEM encoded PKCS#8 .p8 file.
+func ParsePrivateKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed ParsePKCS8PrivateKey")
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not ecdsa")
+	}
+
+	return ecKey, nil
+}
+
+// Sign - signs offerID of productID for appAccountToken, which may be empty, with a fresh nonce.
+func (s *PromotionalOfferSigner) Sign(productID, offerID, appAccountToken string) (res *PromotionalOffer, err error) {
+	nonce, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var now = time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	res = &PromotionalOffer{
+		KeyID:           s.KeyID,
+		ProductID:       productID,
+		OfferID:         offerID,
+		AppAccountToken: strings.ToLower(appAccountToken),
+		Nonce:           nonce,
+		Timestamp:       now.UnixMilli(),
+	}
+
+	digest := sha256.Sum256([]byte(res.payload(s.BundleID)))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, s.Key, digest[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed SignASN1")
+	}
+
+	res.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	return res, nil
+}
+
+// VerifyPromotionalOffer - checks signature of offer for bundleID, counterpart of PromotionalOfferSigner.Sign.
+func VerifyPromotionalOffer(pub *ecdsa.PublicKey, bundleID string, offer *PromotionalOffer) error {
+	sig, err := base64.StdEncoding.DecodeString(offer.Signature)
+	if err != nil {
+		return errors.Wrap(err, "failed decode signature")
+	}
+
+	digest := sha256.Sum256([]byte(offer.payload(bundleID)))
+
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+// payload - signed values in the order StoreKit expects.
+func (o *PromotionalOffer) payload(bundleID string) string {
+	return strings.Join([]string{
+		bundleID,
+		o.KeyID,
+		o.ProductID,
+		o.OfferID,
+		strings.ToLower(o.AppAccountToken),
+		strings.ToLower(o.Nonce),
+		strconv.FormatInt(o.Timestamp, 10),
+	}, promotionalOfferSeparator)
+}
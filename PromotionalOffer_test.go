@@ -0,0 +1,89 @@
+package AppleTransactions
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestPromotionalOfferPayload(t *testing.T) {
+	var offer = &PromotionalOffer{
+		KeyID:           "ABC123DEF4",
+		ProductID:       "com.example.app.monthly",
+		OfferID:         "winback",
+		AppAccountToken: "E2A1F0C4-6D1B-4B8E-9F3A-2C7D5E8B1A90",
+		Nonce:           "9C4B7E12-3F5A-4D6B-8E1C-0A2B3C4D5E6F",
+		Timestamp:       1700000000123,
+	}
+
+	// fields joined with U+2063 INVISIBLE SEPARATOR, uuids lowercased
+	const want = "com.example.app\xe2\x81\xa3ABC123DEF4\xe2\x81\xa3com.example.app.monthly\xe2\x81\xa3winback\xe2\x81\xa3" +
+		"e2a1f0c4-6d1b-4b8e-9f3a-2c7d5e8b1a90\xe2\x81\xa39c4b7e12-3f5a-4d6b-8e1c-0a2b3c4d5e6f\xe2\x81\xa31700000000123"
+
+	if got := offer.payload("com.example.app"); got != want {
+		t.Errorf("payload %q, want %q", got, want)
+	}
+
+	offer.AppAccountToken = ""
+
+	const withoutToken = "com.example.app\xe2\x81\xa3ABC123DEF4\xe2\x81\xa3com.example.app.monthly\xe2\x81\xa3winback\xe2\x81\xa3" +
+		"\xe2\x81\xa39c4b7e12-3f5a-4d6b-8e1c-0a2b3c4d5e6f\xe2\x81\xa31700000000123"
+
+	if got := offer.payload("com.example.app"); got != withoutToken {
+		t.Errorf("payload without token %q, want %q", got, withoutToken)
+	}
+}
+
+func TestPromotionalOfferSign(t *testing.T) {
+	var (
+		key = testECKey(t)
+		now = time.UnixMilli(1700000000123)
+		s   = &PromotionalOfferSigner{BundleID: "com.example.app", KeyID: "ABC123DEF4", Key: key, Now: func() time.Time { return now }}
+	)
+
+	offer, err := s.Sign("com.example.app.monthly", "winback", "E2A1F0C4-6D1B-4B8E-9F3A-2C7D5E8B1A90")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offer.Timestamp != now.UnixMilli() || offer.KeyID != s.KeyID || offer.AppAccountToken != "e2a1f0c4-6d1b-4b8e-9f3a-2c7d5e8b1a90" {
+		t.Errorf("offer %+v", offer)
+	}
+
+	// signature covers the documented payload, not only what payload builds
+	sig, err := base64.StdEncoding.DecodeString(offer.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var digest = sha256.Sum256([]byte("com.example.app\u2063ABC123DEF4\u2063com.example.app.monthly\u2063winback\u2063" +
+		"e2a1f0c4-6d1b-4b8e-9f3a-2c7d5e8b1a90\u2063" + offer.Nonce + "\u20631700000000123"))
+
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("signature doesn't match documented payload")
+	}
+
+	if err = VerifyPromotionalOffer(&key.PublicKey, "com.example.app", offer); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+
+	for name, tamper := range map[string]func(o *PromotionalOffer){
+		"product":   func(o *PromotionalOffer) { o.ProductID = "com.example.app.yearly" },
+		"offer":     func(o *PromotionalOffer) { o.OfferID = "free" },
+		"timestamp": func(o *PromotionalOffer) { o.Timestamp++ },
+		"key":       func(o *PromotionalOffer) { o.KeyID = "OTHER" },
+	} {
+		var copied = *offer
+		tamper(&copied)
+
+		if err = VerifyPromotionalOffer(&key.PublicKey, "com.example.app", &copied); err == nil {
+			t.Errorf("%s tampered offer verified", name)
+		}
+	}
+
+	if err = VerifyPromotionalOffer(&key.PublicKey, "com.example.other", offer); err == nil {
+		t.Error("offer of other bundle verified")
+	}
+}
@@ -0,0 +1,193 @@
+package AppleTransactions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EndpointFamily - Apple endpoints sharing one rate limit.
+type EndpointFamily string
+
+const (
+	EndpointVerifyReceipt EndpointFamily = "verifyReceipt"
+	EndpointServerAPI     EndpointFamily = "serverAPI"
+)
+
+// maxRateLimitRetries - how many times request throttled by Apple is retried after Retry-After.
+const maxRateLimitRetries = 2
+
+// RateLimit - client side limits of an endpoint family.
+type RateLimit struct {
+	// Rate - requests per second, unlimited if 0.
+	Rate float64
+	// Burst - requests allowed at once above Rate, 1 if 0.
+	Burst int
+	// MaxInFlight - concurrent requests, unlimited if 0.
+	MaxInFlight int
+}
+
+// RateLimitError - request wasn't sent, because waiting for a slot would exceed context deadline,
+// or Apple responded 429. RetryAfter is 0 if all in flight slots stayed busy until deadline.
+type RateLimitError struct {
+	Endpoint   EndpointFamily
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %s", e.Endpoint, e.RetryAfter)
+}
+
+// limiter - token bucket with cap of requests in flight.
+type limiter struct {
+	endpoint EndpointFamily
+	rate     float64
+	burst    float64
+	slots    chan struct{}
+
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// limiter - limiter of endpoint family, created on first use.
+func (c *Client) limiter(endpoint EndpointFamily) *limiter {
+	c.limitersMu.Lock()
+	defer c.limitersMu.Unlock()
+
+	if l, ok := c.limiters[endpoint]; ok {
+		return l
+	}
+
+	if c.limiters == nil {
+		c.limiters = make(map[EndpointFamily]*limiter)
+	}
+
+	var (
+		cfg = c.RateLimits[endpoint]
+		l   = &limiter{endpoint: endpoint, rate: cfg.Rate, burst: float64(cfg.Burst)}
+	)
+
+	if l.burst < 1 {
+		l.burst = 1
+	}
+
+	l.tokens = l.burst
+
+	if cfg.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	c.limiters[endpoint] = l
+
+	return l
+}
+
+// wait - blocks until request may be sent, release must be called once it's done.
+//
+// Fails fast with RateLimitError if request can't be sent before deadline, zero deadline means none.
+func (l *limiter) wait(ctx context.Context, deadline time.Time) (release func(), err error) {
+	delay, err := l.reserve(time.Now(), deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	if delay > 0 {
+		var timer = time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	// nil channel never fires without deadline
+	var expired <-chan time.Time
+
+	if !deadline.IsZero() {
+		var timer = time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-expired:
+		return nil, &RateLimitError{Endpoint: l.endpoint}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reserve - takes a token, returns delay until it's available.
+func (l *limiter) reserve(now, deadline time.Time) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var delay time.Duration
+
+	if l.rate > 0 {
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.rate
+			if l.tokens > l.burst {
+				l.tokens = l.burst
+			}
+		}
+
+		l.last = now
+		l.tokens--
+
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+	}
+
+	if blocked := l.blockedUntil.Sub(now); blocked > delay {
+		delay = blocked
+	}
+
+	if !deadline.IsZero() && now.Add(delay).After(deadline) {
+		if l.rate > 0 {
+			l.tokens++
+		}
+
+		return 0, &RateLimitError{Endpoint: l.endpoint, RetryAfter: delay}
+	}
+
+	return delay, nil
+}
+
+// block - holds requests back for d, after Apple responded 429.
+func (l *limiter) block(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// parseRetryAfter - Retry-After header in seconds or HTTP date, 1 second if missing.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return time.Second
+}
@@ -0,0 +1,44 @@
+package AppleTransactions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLimiterSlotDeadline(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		client = &Client{RateLimits: map[EndpointFamily]RateLimit{EndpointVerifyReceipt: {MaxInFlight: 1}}}
+		lim    = client.limiter(EndpointVerifyReceipt)
+	)
+
+	release, err := lim.wait(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var started = time.Now()
+
+	// context without deadline, only the deadline of the request bounds the wait
+	_, err = lim.wait(ctx, started.Add(50*time.Millisecond))
+
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.Endpoint != EndpointVerifyReceipt {
+		t.Fatalf("busy slot: %v, want RateLimitError", err)
+	}
+
+	if waited := time.Since(started); waited > 5*time.Second {
+		t.Errorf("waited %s for busy slot", waited)
+	}
+
+	release()
+
+	if release, err = lim.wait(ctx, time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatalf("free slot: %v", err)
+	}
+
+	release()
+}
@@ -0,0 +1,249 @@
+package AppleTransactions
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// https://developer.apple.com/library/archive/releasenotes/General/ValidateAppStoreReceipt/Chapters/ReceiptFields.html
+
+// receipt attribute types
+const (
+	attrReceiptType                = 0
+	attrBundleID                   = 2
+	attrApplicationVersion         = 3
+	attrOpaqueValue                = 4
+	attrSHA1Hash                   = 5
+	attrCreationDate               = 12
+	attrInAppPurchase              = 17
+	attrOriginalPurchaseDate       = 18
+	attrOriginalApplicationVersion = 19
+	attrExpirationDate             = 21
+)
+
+// in-app purchase attribute types
+const (
+	attrQuantity              = 1701
+	attrProductID             = 1702
+	attrTransactionID         = 1703
+	attrPurchaseDate          = 1704
+	attrOriginalTransactionID = 1705
+	attrOriginalPurchaseDate2 = 1706
+	attrExpiresDate           = 1708
+	attrWebOrderLineItemID    = 1711
+	attrCancellationDate      = 1712
+	attrIsTrialPeriod         = 1713
+	attrIsInIntroOfferPeriod  = 1719
+)
+
+type receiptAttribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+// ParsedReceipt - app receipt decoded locally, without contacting Apple.
+type ParsedReceipt struct {
+	// Environment - receipt type, "Production" or "ProductionSandbox".
+	Environment                string
+	BundleID                   string
+	ApplicationVersion         string
+	OriginalApplicationVersion string
+	OpaqueValue                []byte
+	SHA1Hash                   []byte
+
+	// CreatedAt - Unix timestamp.
+	CreatedAt int64
+	// ExpireAt - Unix timestamp, 0 if receipt doesn't expire.
+	ExpireAt int64
+
+	receipt receipt
+
+	// signed, content and bundleIDRaw are needed to verify the receipt.
+	signed      pkcs7SignedData
+	content     []byte
+	bundleIDRaw []byte
+}
+
+// ParseReceipt - decodes base64 PKCS#7 app receipt into the same model verifyReceipt returns.
+//
+// Signature isn't checked, receipt may be forged until ReceiptVerifier accepts it.
+func ParseReceipt(data string) (res *ParsedReceipt, err error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed decode base64")
+	}
+
+	signed, content, err := parsePKCS7(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsePKCS7 fail")
+	}
+
+	attrs, err := parseReceiptAttributes(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseReceiptAttributes fail")
+	}
+
+	res = &ParsedReceipt{signed: signed, content: content}
+
+	for _, a := range attrs {
+		switch a.Type {
+		case attrReceiptType:
+			res.Environment, err = attrString(a.Value)
+			res.receipt.ReceiptType = res.Environment
+		case attrBundleID:
+			res.BundleID, err = attrString(a.Value)
+			res.receipt.BundleID = res.BundleID
+			res.bundleIDRaw = a.Value
+		case attrApplicationVersion:
+			res.ApplicationVersion, err = attrString(a.Value)
+			res.receipt.ApplicationVersion = res.ApplicationVersion
+		case attrOriginalApplicationVersion:
+			res.OriginalApplicationVersion, err = attrString(a.Value)
+			res.receipt.OriginalApplicationVersion = res.OriginalApplicationVersion
+		case attrOpaqueValue:
+			res.OpaqueValue = a.Value
+		case attrSHA1Hash:
+			res.SHA1Hash = a.Value
+		case attrCreationDate:
+			res.receipt.ReceiptCreationDate, res.receipt.ReceiptCreationDateMS, err = attrDate(a.Value)
+			res.CreatedAt, _ = unixFromMS(res.receipt.ReceiptCreationDateMS)
+		case attrOriginalPurchaseDate:
+			res.receipt.OriginalPurchaseDate, res.receipt.OriginalPurchaseDateMS, err = attrDate(a.Value)
+		case attrExpirationDate:
+			var ms string
+			if _, ms, err = attrDate(a.Value); err == nil {
+				res.ExpireAt, _ = unixFromMS(ms)
+			}
+		case attrInAppPurchase:
+			var purchase inApp
+			if purchase, err = parseInApp(a.Value); err == nil {
+				res.receipt.InApp = append(res.receipt.InApp, purchase)
+			}
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "attribute %d", a.Type)
+		}
+	}
+
+	return res, nil
+}
+
+// Transactions - transactions of the receipt, as TransactionsByReceipt would return them.
+func (p *ParsedReceipt) Transactions() ([]Transaction, error) {
+	var data = receiptData{Receipt: p.receipt}
+
+	return data.collectTransactions()
+}
+
+func parseReceiptAttributes(data []byte) (attrs []receiptAttribute, err error) {
+	rest, err := asn1.UnmarshalWithParams(data, &attrs, "set")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after attributes")
+	}
+
+	return attrs, nil
+}
+
+func parseInApp(data []byte) (res inApp, err error) {
+	attrs, err := parseReceiptAttributes(data)
+	if err != nil {
+		return res, errors.Wrap(err, "parseReceiptAttributes fail")
+	}
+
+	for _, a := range attrs {
+		switch a.Type {
+		case attrQuantity:
+			res.Quantity, err = attrInt(a.Value)
+		case attrProductID:
+			res.ProductID, err = attrString(a.Value)
+		case attrTransactionID:
+			res.TransactionID, err = attrString(a.Value)
+		case attrOriginalTransactionID:
+			res.OriginalTransactionID, err = attrString(a.Value)
+		case attrPurchaseDate:
+			res.PurchaseDate, res.PurchaseDateMS, err = attrDate(a.Value)
+		case attrOriginalPurchaseDate2:
+			res.OriginalPurchaseDate, res.OriginalPurchaseDateMS, err = attrDate(a.Value)
+		case attrExpiresDate:
+			res.ExpiresDate, res.ExpiresDateMS, err = attrDate(a.Value)
+		case attrCancellationDate:
+			res.CancellationDate, res.CancellationDateMS, err = attrDate(a.Value)
+		case attrWebOrderLineItemID:
+			res.WebOrderLineItemID, err = attrInt(a.Value)
+		case attrIsTrialPeriod:
+			res.IsTrialPeriod, err = attrBool(a.Value)
+		case attrIsInIntroOfferPeriod:
+			res.IsInIntroOfferPeriod, err = attrBool(a.Value)
+		}
+
+		if err != nil {
+			return res, errors.Wrapf(err, "in-app attribute %d", a.Type)
+		}
+	}
+
+	return res, nil
+}
+
+// attrString - UTF8String or IA5String value.
+func attrString(value []byte) (string, error) {
+	var raw asn1.RawValue
+
+	if _, err := asn1.Unmarshal(value, &raw); err != nil {
+		return "", err
+	}
+
+	return string(raw.Bytes), nil
+}
+
+// attrInt - INTEGER value as verifyReceipt formats it.
+func attrInt(value []byte) (string, error) {
+	var n int64
+
+	if _, err := asn1.Unmarshal(value, &n); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(n, 10), nil
+}
+
+// attrBool - INTEGER value as "true" or "false".
+func attrBool(value []byte) (string, error) {
+	n, err := attrInt(value)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatBool(n != "0"), nil
+}
+
+// attrDate - RFC 3339 IA5String value as date and milliseconds string, empty if date isn't set.
+func attrDate(value []byte) (date, ms string, err error) {
+	if date, err = attrString(value); err != nil || date == "" {
+		return "", "", err
+	}
+
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", "", err
+	}
+
+	return date, strconv.FormatInt(t.UnixMilli(), 10), nil
+}
+
+// unixFromMS - like msToTime, but 0 for empty string.
+func unixFromMS(ms string) (int64, error) {
+	if ms == "" {
+		return 0, nil
+	}
+
+	return msToTime(ms)
+}
@@ -0,0 +1,261 @@
+package AppleTransactions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"github.com/pkg/errors"
+	"math/big"
+	"time"
+)
+
+var (
+	oidDigestSHA1        = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// ReceiptVerifier - checks app receipts locally, so forged receipts are rejected before verifyReceipt call.
+//
+// Both receipt generations are supported: SHA-1 signed ones and SHA-256 signed ones
+// issued since 2023. Certificates must be valid at receipt creation date, as Apple recommends.
+type ReceiptVerifier struct {
+	// RootCertificates - trusted roots, usually "Apple Inc. Root".
+	// Go refuses SHA-1 chains, so certificates are checked here instead of x509.CertPool.
+	RootCertificates []*x509.Certificate
+
+	// Intermediates - WWDR intermediates in case receipt doesn't embed them.
+	Intermediates []*x509.Certificate
+
+	// BundleID - when set, receipts of other apps are rejected.
+	BundleID string
+}
+
+// NewReceiptVerifier - verifier trusting given root certificates (PEM or DER).
+func NewReceiptVerifier(bundleID string, rootCertificates ...[]byte) (*ReceiptVerifier, error) {
+	roots, err := parseCertificates(rootCertificates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiptVerifier{RootCertificates: roots, BundleID: bundleID}, nil
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// Verify - checks PKCS#7 signature, certificate chain and bundle ID of p.
+func (v *ReceiptVerifier) Verify(p *ParsedReceipt) error {
+	if len(v.RootCertificates) == 0 {
+		return errors.New("no root certificates")
+	}
+
+	if v.BundleID != "" && p.BundleID != v.BundleID {
+		return errors.Errorf("unexpected bundle id %q", p.BundleID)
+	}
+
+	if len(p.signed.SignerInfos) != 1 {
+		return errors.Errorf("expected 1 signer, got %d", len(p.signed.SignerInfos))
+	}
+
+	certs, err := x509.ParseCertificates(p.signed.Certificates.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "failed ParseCertificates")
+	}
+
+	var si = p.signed.SignerInfos[0]
+
+	signer, err := findSigner(certs, si)
+	if err != nil {
+		return err
+	}
+
+	var at = time.Unix(p.CreatedAt, 0)
+
+	if err = v.verifyChain(signer, append(certs, v.Intermediates...), at); err != nil {
+		return errors.Wrap(err, "verifyChain fail")
+	}
+
+	if err = verifySignerInfo(signer, si, p.content); err != nil {
+		return errors.Wrap(err, "verifySignerInfo fail")
+	}
+
+	return nil
+}
+
+// VerifyDevice - checks receipt hash against identifierForVendor of the device it was issued to.
+func (p *ParsedReceipt) VerifyDevice(deviceID string) error {
+	guid, err := parseUUID(deviceID)
+	if err != nil {
+		return err
+	}
+
+	var h = sha1.New()
+	h.Write(guid[:])
+	h.Write(p.OpaqueValue)
+	h.Write(p.bundleIDRaw)
+
+	if len(p.SHA1Hash) == 0 || subtle.ConstantTimeCompare(h.Sum(nil), p.SHA1Hash) != 1 {
+		return errors.New("receipt hash mismatch")
+	}
+
+	return nil
+}
+
+func findSigner(certs []*x509.Certificate, si pkcs7SignerInfo) (*x509.Certificate, error) {
+	var id pkcs7IssuerAndSerial
+
+	if _, err := asn1.Unmarshal(si.IssuerAndSerialNumber.FullBytes, &id); err != nil {
+		return nil, errors.Wrap(err, "failed Unmarshal IssuerAndSerialNumber")
+	}
+
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(id.Serial) == 0 && bytes.Equal(cert.RawIssuer, id.Issuer.FullBytes) {
+			return cert, nil
+		}
+	}
+
+	return nil, errors.New("signer certificate not found")
+}
+
+// verifyChain - leaf must be Apple receipt signing certificate issued by a WWDR intermediate
+// issued by one of RootCertificates, each valid at time at.
+//
+// Marker extensions are required, as developer certificates chain to the same root through WWDR too.
+func (v *ReceiptVerifier) verifyChain(leaf *x509.Certificate, pool []*x509.Certificate, at time.Time) error {
+	if !hasExtension(leaf, oidAppleLeaf) {
+		return errors.Errorf("certificate %q is not a receipt signing certificate", leaf.Subject.CommonName)
+	}
+
+	var intermediate *x509.Certificate
+
+	for _, c := range pool {
+		if c.IsCA && bytes.Equal(c.RawSubject, leaf.RawIssuer) && checkCertSignature(c, leaf) == nil {
+			intermediate = c
+			break
+		}
+	}
+
+	if intermediate == nil {
+		return errors.Errorf("no issuer for %q", leaf.Subject.CommonName)
+	}
+
+	if !hasExtension(intermediate, oidAppleIntermediate) {
+		return errors.Errorf("certificate %q is not a WWDR intermediate", intermediate.Subject.CommonName)
+	}
+
+	var root *x509.Certificate
+
+	for _, c := range v.RootCertificates {
+		if bytes.Equal(c.RawSubject, intermediate.RawIssuer) && checkCertSignature(c, intermediate) == nil {
+			root = c
+			break
+		}
+	}
+
+	if root == nil {
+		return errors.Errorf("no trusted root for %q", intermediate.Subject.CommonName)
+	}
+
+	for _, c := range []*x509.Certificate{leaf, intermediate, root} {
+		if at.Before(c.NotBefore) || at.After(c.NotAfter) {
+			return errors.Errorf("certificate %q not valid at %s", c.Subject.CommonName, at)
+		}
+	}
+
+	return nil
+}
+
+// checkCertSignature - like x509 CheckSignatureFrom, but accepts SHA-1 of older receipts.
+func checkCertSignature(parent, cert *x509.Certificate) error {
+	if cert.SignatureAlgorithm == x509.SHA1WithRSA {
+		digest := sha1.Sum(cert.RawTBSCertificate)
+
+		return verifyDigest(parent.PublicKey, crypto.SHA1, digest[:], cert.Signature)
+	}
+
+	return parent.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+}
+
+// verifySignerInfo - signature over content, directly or through authenticated attributes.
+func verifySignerInfo(signer *x509.Certificate, si pkcs7SignerInfo, content []byte) error {
+	var hash crypto.Hash
+
+	switch {
+	case si.DigestAlgorithm.Algorithm.Equal(oidDigestSHA1):
+		hash = crypto.SHA1
+	case si.DigestAlgorithm.Algorithm.Equal(oidDigestSHA256):
+		hash = crypto.SHA256
+	default:
+		return errors.Errorf("unsupported digest %s", si.DigestAlgorithm.Algorithm)
+	}
+
+	var h = hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	if len(si.AuthenticatedAttributes.Bytes) == 0 {
+		return verifyDigest(signer.PublicKey, hash, digest, si.EncryptedDigest)
+	}
+
+	var attrs []pkcs7Attribute
+
+	if _, err := asn1.UnmarshalWithParams(si.AuthenticatedAttributes.FullBytes, &attrs, "set,tag:0"); err != nil {
+		return errors.Wrap(err, "failed Unmarshal authenticated attributes")
+	}
+
+	var matched bool
+
+	for _, a := range attrs {
+		if !a.Type.Equal(oidAttrMessageDigest) || len(a.Values) != 1 {
+			continue
+		}
+
+		var md []byte
+
+		if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &md); err != nil {
+			return errors.Wrap(err, "failed Unmarshal messageDigest")
+		}
+
+		matched = bytes.Equal(md, digest)
+	}
+
+	if !matched {
+		return errors.New("message digest mismatch")
+	}
+
+	// attributes are signed as SET, not as the implicit [0] they are sent in
+	signed := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+
+	h = hash.New()
+	h.Write(signed)
+
+	return verifyDigest(signer.PublicKey, hash, h.Sum(nil), si.EncryptedDigest)
+}
+
+func verifyDigest(pub interface{}, hash crypto.Hash, digest, sig []byte) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return errors.New("invalid ecdsa signature")
+		}
+
+		return nil
+	default:
+		return errors.Errorf("unsupported key %T", pub)
+	}
+}
@@ -0,0 +1,284 @@
+package AppleTransactions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readReceipt(t *testing.T, name string) *ParsedReceipt {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ParseReceipt(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func appleRoot(t *testing.T) []byte {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/AppleIncRootCertificate.cer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func TestReceiptVerifierSHA1(t *testing.T) {
+	for _, name := range []string{"receipt_sha1_production.b64", "receipt_sha1_sandbox.b64"} {
+		t.Run(name, func(t *testing.T) {
+			var p = readReceipt(t, name)
+
+			v, err := NewReceiptVerifier("com.devsisters.gb", appleRoot(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = v.Verify(p); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			v.BundleID = "com.example.other"
+			if err = v.Verify(p); err == nil {
+				t.Error("receipt of another bundle accepted")
+			}
+
+			v.BundleID = ""
+
+			var tampered = *p
+			tampered.content = append([]byte(nil), p.content...)
+			tampered.content[len(tampered.content)-1] ^= 1
+
+			if err = v.Verify(&tampered); err == nil {
+				t.Error("tampered receipt accepted")
+			}
+
+			var chain = newTestChain(t, time.Unix(p.CreatedAt, 0))
+
+			if err = (&ReceiptVerifier{RootCertificates: []*x509.Certificate{chain.root}}).Verify(p); err == nil {
+				t.Error("receipt accepted by untrusted root")
+			}
+		})
+	}
+}
+
+// No real SHA-256 receipt is publicly redistributable, so SHA-256 receipts are built
+// the way Apple signs them since 2023, on a test chain carrying Apple marker extensions.
+func TestReceiptVerifierSHA256(t *testing.T) {
+	var (
+		createdAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		chain     = newTestChain(t, createdAt)
+		verifier  = &ReceiptVerifier{RootCertificates: []*x509.Certificate{chain.root}, BundleID: "com.example.app"}
+	)
+
+	p, err := ParseReceipt(chain.receipt(t, "com.example.app", createdAt, chain.leaf, chain.intermediate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = verifier.Verify(p); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	var (
+		developer    = issue(t, "Developer", chain.key, chain.intermediate, chain.intermediateKey, nil, false, createdAt)
+		plainCA      = issue(t, "Plain CA", chain.intermediateKey, chain.root, chain.rootKey, nil, true, createdAt)
+		plainCALeaf  = issue(t, "Leaf", chain.key, plainCA, chain.intermediateKey, oidAppleLeaf, false, createdAt)
+		direct       = issue(t, "Direct", chain.key, chain.root, chain.rootKey, oidAppleLeaf, false, createdAt)
+		expiredLeaf  = issue(t, "Expired", chain.key, chain.intermediate, chain.intermediateKey, oidAppleLeaf, false, createdAt.AddDate(-2, 0, 0))
+		untrustedCA  = newTestChain(t, createdAt)
+		untrusted, _ = ParseReceipt(untrustedCA.receipt(t, "com.example.app", createdAt, untrustedCA.leaf, untrustedCA.intermediate))
+	)
+
+	for _, c := range []struct {
+		name  string
+		certs []*x509.Certificate
+		want  string
+	}{
+		{"leaf without receipt signing marker", []*x509.Certificate{developer, chain.intermediate}, "not a receipt signing"},
+		{"intermediate without WWDR marker", []*x509.Certificate{plainCALeaf, plainCA}, "not a WWDR intermediate"},
+		{"leaf issued by root", []*x509.Certificate{direct, chain.root}, "not a WWDR intermediate"},
+		{"leaf expired at creation", []*x509.Certificate{expiredLeaf, chain.intermediate}, "not valid at"},
+	} {
+		p, err := ParseReceipt(chain.receipt(t, "com.example.app", createdAt, c.certs...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = verifier.Verify(p); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	if err = verifier.Verify(untrusted); err == nil || !strings.Contains(err.Error(), "no trusted root") {
+		t.Errorf("untrusted root: got %v", err)
+	}
+}
+
+// testChain - root, WWDR-like intermediate and receipt signing leaf, all RSA with SHA-256.
+type testChain struct {
+	root, intermediate, leaf      *x509.Certificate
+	rootKey, intermediateKey, key *rsa.PrivateKey
+}
+
+func newTestChain(t *testing.T, at time.Time) *testChain {
+	t.Helper()
+
+	var c = &testChain{rootKey: testKey(t), intermediateKey: testKey(t), key: testKey(t)}
+
+	c.root = issue(t, "Test Root", c.rootKey, nil, nil, nil, true, at)
+	c.intermediate = issue(t, "Test WWDR", c.intermediateKey, c.root, c.rootKey, oidAppleIntermediate, true, at)
+	c.leaf = issue(t, "Test Receipt Signing", c.key, c.intermediate, c.intermediateKey, oidAppleLeaf, false, at)
+
+	return c
+}
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// issue - certificate of key valid for a year around at, signed by parent, self-signed if parent is nil,
+// with marker extension if set.
+func issue(t *testing.T, name string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer,
+	marker asn1.ObjectIdentifier, ca bool, at time.Time) *x509.Certificate {
+	t.Helper()
+
+	var template = &x509.Certificate{
+		SerialNumber:          big.NewInt(at.UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             at.AddDate(0, -6, 0),
+		NotAfter:              at.AddDate(0, 6, 0),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+	}
+
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	if marker != nil {
+		template.ExtraExtensions = []pkix.Extension{{Id: marker, Value: []byte{0x05, 0x00}}}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// receipt - base64 receipt of bundleID signed by c.key over authenticated attributes, certs embedded, first is signer.
+func (c *testChain) receipt(t *testing.T, bundleID string, createdAt time.Time, certs ...*x509.Certificate) string {
+	t.Helper()
+
+	var content = mustMarshal(t, []receiptAttribute{
+		{Type: attrReceiptType, Version: 1, Value: mustMarshal(t, "Production", "utf8")},
+		{Type: attrBundleID, Version: 1, Value: mustMarshal(t, bundleID, "utf8")},
+		{Type: attrApplicationVersion, Version: 1, Value: mustMarshal(t, "1", "utf8")},
+		{Type: attrCreationDate, Version: 1, Value: mustMarshal(t, createdAt.Format(time.RFC3339), "ia5")},
+	}, "set")
+
+	var digest = sha256.Sum256(content)
+
+	attrs := mustMarshal(t, []pkcs7Attribute{
+		{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, Values: []asn1.RawValue{{FullBytes: mustMarshal(t, oidPKCS7Data, "")}}},
+		{Type: oidAttrMessageDigest, Values: []asn1.RawValue{{FullBytes: mustMarshal(t, digest[:], "")}}},
+	}, "set")
+
+	var wrapped asn1.RawValue
+	if _, err := asn1.Unmarshal(attrs, &wrapped); err != nil {
+		t.Fatal(err)
+	}
+
+	var attrsDigest = sha256.Sum256(attrs)
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, attrsDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+
+	var sha256Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+
+	signed := mustMarshal(t, pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidPKCS7Data,
+			Content:     contextTag(mustMarshal(t, content, "")),
+		},
+		Certificates: contextTag(raw),
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: asn1.RawValue{FullBytes: mustMarshal(t, pkcs7IssuerAndSerial{
+				Issuer: asn1.RawValue{FullBytes: certs[0].RawIssuer},
+				Serial: certs[0].SerialNumber,
+			}, "")},
+			DigestAlgorithm:           sha256Algorithm,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: wrapped.Bytes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			EncryptedDigest:           sig,
+		}},
+	}, "")
+
+	return base64.StdEncoding.EncodeToString(mustMarshal(t, pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     contextTag(signed),
+	}, ""))
+}
+
+// contextTag - explicit [0] around DER, encoding/asn1 ignores tags of RawValue fields.
+func contextTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: der}
+}
+
+func mustMarshal(t *testing.T, v interface{}, params string) []byte {
+	t.Helper()
+
+	raw, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
@@ -0,0 +1,146 @@
+package AppleTransactions
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Redact - stand-in for a secret, receipt or token in logs.
+//
+// Keeps length and a short fingerprint, so log lines of one value can be correlated, never the value itself.
+func Redact(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	var sum = sha256.Sum256([]byte(s))
+
+	return fmt.Sprintf("[redacted %d bytes %x]", len(s), sum[:4])
+}
+
+// redactedKey - stand-in for a private key, which is never printed in any form.
+const redactedKey = "[redacted key]"
+
+// Types below implement fmt.Formatter, so printing them with any verb, including %#v,
+// prints a copy with secrets redacted. Local type plain drops the Format method of the copy.
+
+func (q appleQuery) Format(f fmt.State, verb rune) {
+	type plain appleQuery
+	q.ReceiptData, q.Password = Redact(q.ReceiptData), Redact(q.Password)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(q))
+}
+
+func (r ReceiptResult) Format(f fmt.State, verb rune) {
+	type plain ReceiptResult
+	r.LatestReceipt = Redact(r.LatestReceipt)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(r))
+}
+
+func (s Secret) Format(f fmt.State, verb rune) {
+	type plain Secret
+	s.Value = Redact(s.Value)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(s))
+}
+
+func (k SigningKey) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, fmt.FormatString(f, verb), struct {
+		IssuerID string
+		KeyID    string
+		Key      string
+	}{k.IssuerID, k.KeyID, redactedKey})
+}
+
+func (s *PromotionalOfferSigner) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, fmt.FormatString(f, verb), struct {
+		BundleID string
+		KeyID    string
+		Key      string
+	}{s.BundleID, s.KeyID, redactedKey})
+}
+
+// Format - metadata only, opaque value and hash are left out with the receipt, as they prove device ownership.
+func (p ParsedReceipt) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, fmt.FormatString(f, verb), struct {
+		Environment                string
+		BundleID                   string
+		ApplicationVersion         string
+		OriginalApplicationVersion string
+		CreatedAt                  int64
+		ExpireAt                   int64
+		Receipt                    string
+	}{p.Environment, p.BundleID, p.ApplicationVersion, p.OriginalApplicationVersion,
+		p.CreatedAt, p.ExpireAt, Redact(string(p.content))})
+}
+
+func (r StoredReceipt) Format(f fmt.State, verb rune) {
+	type plain StoredReceipt
+	r.Receipt = Redact(r.Receipt)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(r))
+}
+
+func (s ScheduledCheck) Format(f fmt.State, verb rune) {
+	type plain ScheduledCheck
+	s.Receipt = Redact(s.Receipt)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(s))
+}
+
+func (j RevalidationJob) Format(f fmt.State, verb rune) {
+	type plain RevalidationJob
+	j.Receipt, j.SharedPassword = Redact(j.Receipt), Redact(j.SharedPassword)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(j))
+}
+
+func (o BulkOptions) Format(f fmt.State, verb rune) {
+	type plain BulkOptions
+	o.SharedPassword = Redact(o.SharedPassword)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), plain(o))
+}
+
+func (s *Scheduler) Format(f fmt.State, verb rune) {
+	type plain Scheduler
+	var c = plain(*s)
+	c.SharedPassword = Redact(c.SharedPassword)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), &c)
+}
+
+func (h *NotificationHandler) Format(f fmt.State, verb rune) {
+	type plain NotificationHandler
+	var c = plain(*h)
+	c.SharedSecret = Redact(c.SharedSecret)
+	fmt.Fprintf(f, fmt.FormatString(f, verb), &c)
+}
+
+// Providers and stores hold secrets in unexported fields, which fmt prints without calling their Format,
+// so only their settings and sizes are printed.
+
+func (p *MemorySecretProvider) Format(f fmt.State, _ rune) {
+	fmt.Fprintf(f, "&MemorySecretProvider{Window:%s}", p.Window)
+}
+
+func (m *MemoryStore) Format(f fmt.State, _ rune) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(f, "&MemoryStore{transactions:%d receipts:%d}", len(m.transactions), len(m.receipts))
+}
+
+func (m *MemoryScheduleStore) Format(f fmt.State, _ rune) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(f, "&MemoryScheduleStore{checks:%d}", len(m.checks))
+}
+
+func (p *FileKeyProvider) Format(f fmt.State, _ rune) {
+	fmt.Fprintf(f, "&FileKeyProvider{IssuerID:%s Dir:%s CheckEvery:%s}", p.IssuerID, p.Dir, p.CheckEvery)
+}
+
+func (p *EnvKeyProvider) Format(f fmt.State, _ rune) {
+	fmt.Fprintf(f, "&EnvKeyProvider{IssuerID:%s Prefix:%s}", p.IssuerID, p.Prefix)
+}
+
+func (p *CachedKeyProvider) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "&CachedKeyProvider{Provider:")
+	fmt.Fprintf(f, fmt.FormatString(f, verb), p.Provider)
+	fmt.Fprintf(f, " TTL:%s}", p.TTL)
+}
@@ -0,0 +1,126 @@
+package AppleTransactions
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactFormat(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		secret  = "0123456789abcdef0123456789abcdef"
+		p       = readReceipt(t, "receipt_sha1_production.b64")
+		receipt = base64Receipt(t, "receipt_sha1_production.b64")
+		key     = testECKey(t)
+	)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p8 = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	var dir = t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "AuthKey_ABC123.p8"), p8, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_REDACT_KEY_ABC123", string(p8))
+
+	var (
+		files   = &FileKeyProvider{IssuerID: "issuer", Dir: dir}
+		env     = &EnvKeyProvider{IssuerID: "issuer", Prefix: "TEST_REDACT_KEY_"}
+		cached  = &CachedKeyProvider{Provider: env}
+		secrets = NewMemorySecretProvider(0)
+		store   = NewMemoryStore()
+		checks  = NewMemoryScheduleStore()
+		job     = RevalidationJob{Receipt: receipt, SharedPassword: secret}
+		result  = ReceiptResult{LatestReceipt: receipt}
+	)
+
+	for _, provider := range []KeyProvider{files, cached} {
+		if keys, err := provider.Keys(ctx, "issuer"); err != nil || len(keys) != 1 {
+			t.Fatalf("%T: %d keys, %v", provider, len(keys), err)
+		}
+	}
+
+	secrets.Rotate("", Secret{Version: "1", Value: secret})
+
+	if err = store.UpsertReceipt(ctx, StoredReceipt{UserID: "user", Receipt: receipt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checks.Put(ctx, ScheduledCheck{OriginalID: "1", Receipt: receipt}); err != nil {
+		t.Fatal(err)
+	}
+
+	var values = []interface{}{
+		appleQuery{ReceiptData: receipt, Password: secret},
+		result,
+		&result,
+		Secret{Version: "1", Value: secret},
+		SigningKey{IssuerID: "issuer", KeyID: "ABC123", Key: key},
+		&PromotionalOfferSigner{BundleID: "com.example.app", KeyID: "ABC123", Key: key},
+		StoredReceipt{UserID: "user", Receipt: receipt},
+		ScheduledCheck{OriginalID: "1", Receipt: receipt},
+		job,
+		RevalidationResult{Job: job, Result: result},
+		BulkOptions{SharedPassword: secret},
+		&Scheduler{Store: checks, SharedPassword: secret},
+		&NotificationHandler{SharedSecret: secret},
+		secrets,
+		store,
+		checks,
+		files,
+		env,
+		cached,
+		*p,
+		p,
+	}
+
+	var forbidden = []string{
+		secret,
+		hex.EncodeToString([]byte(secret)),
+		receipt,
+		hex.EncodeToString([]byte(receipt[:32])),
+		string(p.content[:32]),
+		hex.EncodeToString(p.content[:32]),
+		strings.Trim(fmt.Sprint(p.content[:32]), "[]"),
+		strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%#v", p.content[:32]), "[]byte{"), "}"),
+		strings.Trim(fmt.Sprintf("%q", p.content[:32]), `"`),
+		hex.EncodeToString(p.signed.SignerInfos[0].EncryptedDigest[:32]),
+		key.D.String(),
+		hex.EncodeToString(key.D.Bytes()),
+	}
+
+	for _, v := range values {
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+			var out = fmt.Sprintf(verb, v)
+
+			for _, f := range forbidden {
+				if strings.Contains(out, f) {
+					t.Errorf("%T printed with %s leaks %.16q: %.200q", v, verb, f, out)
+				}
+			}
+		}
+	}
+}
+
+func base64Receipt(t *testing.T, name string) string {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(raw))
+}
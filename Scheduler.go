@@ -0,0 +1,449 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RenewalEventKind - what changed about a tracked subscription.
+type RenewalEventKind string
+
+const (
+	RenewalRenewed   RenewalEventKind = "renewed"
+	RenewalExpired   RenewalEventKind = "expired"
+	RenewalCancelled RenewalEventKind = "cancelled"
+)
+
+// RenewalEvent - change of a tracked subscription found by Scheduler.
+type RenewalEvent struct {
+	Kind       RenewalEventKind
+	OriginalID string
+	UserID     string
+
+	// Transaction - latest transaction of the subscription.
+	Transaction Transaction
+	// PrevExpireAt - Unix timestamp of SubscriptionExpireAt known before the check.
+	PrevExpireAt int64
+}
+
+// ScheduledCheck - queue entry of Scheduler, one per original transaction.
+type ScheduledCheck struct {
+	OriginalID string
+	UserID     string
+	Receipt    string
+
+	// ExpireAt and AccessExpireAt - Unix timestamps of latest known transaction.
+	ExpireAt       int64
+	AccessExpireAt int64
+	AutoRenew      bool
+
+	// NextCheckAt - Unix timestamp of next revalidation.
+	NextCheckAt int64
+	Attempts    int
+}
+
+// ScheduleStore - persists Scheduler queue.
+type ScheduleStore interface {
+	// Put - inserts or replaces check of s.OriginalID.
+	Put(ctx context.Context, s ScheduledCheck) error
+
+	// Due - at most limit checks with NextCheckAt <= now, earliest first.
+	Due(ctx context.Context, now int64, limit int) ([]ScheduledCheck, error)
+
+	// Delete - stops tracking originalID.
+	Delete(ctx context.Context, originalID string) error
+}
+
+// Scheduler - revalidates subscriptions shortly after expiry and during billing retry instead of all receipts.
+type Scheduler struct {
+	Client *Client
+	Store  ScheduleStore
+
+	SharedPassword string
+
+	// CheckDelay - wait after expiry, so Apple has time to renew, 10 minutes if 0.
+	CheckDelay time.Duration
+	// RetryInterval - recheck period within billing retry and after failed checks, 6 hours if 0.
+	RetryInterval time.Duration
+	// PollInterval - how often Run looks for due checks, 1 minute if 0.
+	PollInterval time.Duration
+	// BatchSize - checks per poll, 100 if 0.
+	BatchSize int
+	// MaxAttempts - failed validations in a row before subscription is no longer tracked, 10 if 0.
+	// Only failures retrying doesn't fix count, network failures, 5xx and Store failures are retried
+	// every RetryInterval for good. Receipts Apple rejects, e.g. with status 21010, are dropped at once.
+	MaxAttempts int
+
+	// OnEvent - called for every change, returned error makes the check repeat later.
+	OnEvent func(ctx context.Context, e RenewalEvent) error
+}
+
+// Track - schedules subscriptions of validated receipt of userID.
+func (s *Scheduler) Track(ctx context.Context, userID, receipt string, res ReceiptResult) error {
+	if res.LatestReceipt != "" {
+		receipt = res.LatestReceipt
+	}
+
+	for _, t := range latestSubscriptions(res.Transactions) {
+		var check = ScheduledCheck{
+			OriginalID:     t.OriginalID,
+			UserID:         userID,
+			Receipt:        receipt,
+			ExpireAt:       t.SubscriptionExpireAt,
+			AccessExpireAt: t.AccessExpireAt,
+			AutoRenew:      renewalOf(res.RenewalInfo, t.OriginalID).AutoRenewStatus,
+			NextCheckAt:    t.AccessExpireAt + int64(s.checkDelay()/time.Second),
+		}
+
+		if err := s.Store.Put(ctx, check); err != nil {
+			return errors.Wrap(err, "schedule Put")
+		}
+	}
+
+	return nil
+}
+
+// Run - processes due checks every PollInterval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var ticker = time.NewTicker(s.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RunOnce - processes checks due at now, returns how many were processed.
+func (s *Scheduler) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	var batch = s.BatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+
+	due, err := s.Store.Due(ctx, now.Unix(), batch)
+	if err != nil {
+		return 0, errors.Wrap(err, "schedule Due")
+	}
+
+	for _, check := range due {
+		if err = s.check(ctx, check, now); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// check - revalidates one subscription, emits changes and reschedules it.
+func (s *Scheduler) check(ctx context.Context, check ScheduledCheck, now time.Time) error {
+	var retry = now.Add(s.retryInterval()).Unix()
+
+	res, err := s.Client.ValidateReceipt(ctx, check.Receipt, s.SharedPassword, ForUser(check.UserID))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		check.NextCheckAt = retry
+
+		var stored storeError
+		if temporary(err) || errors.As(err, &stored) {
+			return errors.Wrap(s.Store.Put(ctx, check), "schedule Put")
+		}
+
+		check.Attempts++
+
+		if rejected(err) || check.Attempts >= s.maxAttempts() {
+			return errors.Wrap(s.Store.Delete(ctx, check.OriginalID), "schedule Delete")
+		}
+
+		return errors.Wrap(s.Store.Put(ctx, check), "schedule Put")
+	}
+
+	if res.LatestReceipt != "" {
+		check.Receipt = res.LatestReceipt
+	}
+
+	var latest, found = Transaction{}, false
+
+	for _, t := range latestSubscriptions(res.Transactions) {
+		if t.OriginalID == check.OriginalID {
+			latest, found = t, true
+		}
+	}
+
+	if !found {
+		return errors.Wrap(s.Store.Delete(ctx, check.OriginalID), "schedule Delete")
+	}
+
+	var (
+		info   = renewalOf(res.RenewalInfo, check.OriginalID)
+		event  = RenewalEvent{OriginalID: check.OriginalID, UserID: check.UserID, Transaction: latest, PrevExpireAt: check.ExpireAt}
+		next   = latest.AccessExpireAt + int64(s.checkDelay()/time.Second)
+		kinds  []RenewalEventKind
+		finish bool
+	)
+
+	if latest.RevokedAt != 0 {
+		// refunded or revoked by family sharing, never renews
+		kinds, finish = append(kinds, RenewalCancelled), true
+	} else {
+		if latest.SubscriptionExpireAt > check.ExpireAt {
+			kinds = append(kinds, RenewalRenewed)
+		}
+
+		// zero info means Apple sent no renewal info, not that auto-renew was turned off
+		if check.AutoRenew && info.OriginalTransactionID != "" && !info.AutoRenewStatus {
+			kinds = append(kinds, RenewalCancelled)
+		}
+
+		switch {
+		case latest.AccessExpireAt > now.Unix():
+			// active or grace period, wait for its end
+		case info.IsInBillingRetryPeriod:
+			next = retry
+		default:
+			kinds, finish = append(kinds, RenewalExpired), true
+		}
+	}
+
+	for _, kind := range kinds {
+		if s.OnEvent == nil {
+			break
+		}
+
+		// events are emitted again on retry, check keeps state before them
+		event.Kind = kind
+
+		if err = s.OnEvent(ctx, event); err != nil {
+			check.NextCheckAt = retry
+			return errors.Wrap(s.Store.Put(ctx, check), "schedule Put")
+		}
+	}
+
+	if finish {
+		return errors.Wrap(s.Store.Delete(ctx, check.OriginalID), "schedule Delete")
+	}
+
+	check.ExpireAt = latest.SubscriptionExpireAt
+	check.AccessExpireAt = latest.AccessExpireAt
+	check.AutoRenew = info.AutoRenewStatus
+	check.NextCheckAt = next
+	check.Attempts = 0
+
+	if check.NextCheckAt <= now.Unix() {
+		check.NextCheckAt = retry
+	}
+
+	return errors.Wrap(s.Store.Put(ctx, check), "schedule Put")
+}
+
+func (s *Scheduler) checkDelay() time.Duration {
+	if s.CheckDelay > 0 {
+		return s.CheckDelay
+	}
+
+	return 10 * time.Minute
+}
+
+func (s *Scheduler) retryInterval() time.Duration {
+	if s.RetryInterval > 0 {
+		return s.RetryInterval
+	}
+
+	return 6 * time.Hour
+}
+
+func (s *Scheduler) maxAttempts() int {
+	if s.MaxAttempts > 0 {
+		return s.MaxAttempts
+	}
+
+	return 10
+}
+
+// rejected - Apple refused the receipt itself, validating it again gives the same answer.
+//
+// Wrong shared secret (21004) is configuration of Scheduler, not of the receipt, so it's retried.
+func rejected(err error) bool {
+	var status StatusError
+
+	return errors.As(err, &status) && !status.Temporary() && status != 21004
+}
+
+func (s *Scheduler) pollInterval() time.Duration {
+	if s.PollInterval > 0 {
+		return s.PollInterval
+	}
+
+	return time.Minute
+}
+
+// latestSubscriptions - transaction with the latest expiry per original transaction.
+func latestSubscriptions(transactions []Transaction) (res []Transaction) {
+	var latest = make(map[string]int)
+
+	for _, t := range transactions {
+		if t.SubscriptionExpireAt == 0 {
+			continue
+		}
+
+		i, ok := latest[t.OriginalID]
+		if !ok {
+			latest[t.OriginalID] = len(res)
+			res = append(res, t)
+		} else if t.SubscriptionExpireAt > res[i].SubscriptionExpireAt {
+			res[i] = t
+		}
+	}
+
+	return res
+}
+
+// renewalOf - renewal info of originalID, zero value if Apple sent none.
+func renewalOf(renewals []RenewalInfo, originalID string) RenewalInfo {
+	for _, r := range renewals {
+		if r.OriginalTransactionID == originalID {
+			return r
+		}
+	}
+
+	return RenewalInfo{}
+}
+
+// MemoryScheduleStore - in-process ScheduleStore, forgets everything on restart.
+type MemoryScheduleStore struct {
+	mu     sync.Mutex
+	checks map[string]ScheduledCheck
+}
+
+// NewMemoryScheduleStore - empty MemoryScheduleStore.
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{checks: make(map[string]ScheduledCheck)}
+}
+
+func (m *MemoryScheduleStore) Put(_ context.Context, s ScheduledCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks[s.OriginalID] = s
+
+	return nil
+}
+
+func (m *MemoryScheduleStore) Due(_ context.Context, now int64, limit int) (res []ScheduledCheck, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.checks {
+		if s.NextCheckAt <= now {
+			res = append(res, s)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].NextCheckAt < res[j].NextCheckAt })
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (m *MemoryScheduleStore) Delete(_ context.Context, originalID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.checks, originalID)
+
+	return nil
+}
+
+// SQLScheduleStore - ScheduleStore on database/sql, works with SQLite and Postgres.
+type SQLScheduleStore struct {
+	DB *sql.DB
+}
+
+// NewSQLScheduleStore - SQLScheduleStore on db, call Migrate before use.
+func NewSQLScheduleStore(db *sql.DB) *SQLScheduleStore {
+	return &SQLScheduleStore{DB: db}
+}
+
+// Migrate - creates table if it doesn't exist.
+func (m *SQLScheduleStore) Migrate(ctx context.Context) error {
+	for _, q := range []string{
+		`CREATE TABLE IF NOT EXISTS apple_renewal_schedule (
+			original_transaction_id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			receipt TEXT NOT NULL,
+			expire_at BIGINT NOT NULL,
+			access_expire_at BIGINT NOT NULL,
+			auto_renew BOOLEAN NOT NULL,
+			next_check_at BIGINT NOT NULL,
+			attempts INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS apple_renewal_schedule_next_check_at ON apple_renewal_schedule (next_check_at)`,
+	} {
+		if _, err := m.DB.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "failed create table")
+		}
+	}
+
+	return nil
+}
+
+func (m *SQLScheduleStore) Put(ctx context.Context, s ScheduledCheck) error {
+	_, err := m.DB.ExecContext(ctx,
+		`INSERT INTO apple_renewal_schedule
+		(original_transaction_id, user_id, receipt, expire_at, access_expire_at, auto_renew, next_check_at, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (original_transaction_id) DO UPDATE SET
+		user_id = excluded.user_id, receipt = excluded.receipt, expire_at = excluded.expire_at,
+		access_expire_at = excluded.access_expire_at, auto_renew = excluded.auto_renew,
+		next_check_at = excluded.next_check_at, attempts = excluded.attempts`,
+		s.OriginalID, s.UserID, s.Receipt, s.ExpireAt, s.AccessExpireAt, s.AutoRenew, s.NextCheckAt, s.Attempts)
+
+	return errors.Wrap(err, "failed upsert check")
+}
+
+func (m *SQLScheduleStore) Due(ctx context.Context, now int64, limit int) (res []ScheduledCheck, err error) {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT original_transaction_id, user_id, receipt, expire_at, access_expire_at, auto_renew, next_check_at, attempts
+		FROM apple_renewal_schedule WHERE next_check_at <= $1 ORDER BY next_check_at LIMIT $2`,
+		now, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select checks")
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var s ScheduledCheck
+
+		if err = rows.Scan(&s.OriginalID, &s.UserID, &s.Receipt, &s.ExpireAt, &s.AccessExpireAt, &s.AutoRenew, &s.NextCheckAt, &s.Attempts); err != nil {
+			return nil, errors.Wrap(err, "failed Scan")
+		}
+
+		res = append(res, s)
+	}
+
+	return res, errors.Wrap(rows.Err(), "failed rows")
+}
+
+func (m *SQLScheduleStore) Delete(ctx context.Context, originalID string) error {
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM apple_renewal_schedule WHERE original_transaction_id = $1`, originalID)
+
+	return errors.Wrap(err, "failed delete check")
+}
@@ -0,0 +1,111 @@
+package AppleTransactions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchedulerEvents(t *testing.T) {
+	var (
+		now     = time.Unix(1700000000, 0)
+		expired = now.Add(-time.Hour).Unix()
+		active  = now.Add(time.Hour).Unix()
+	)
+
+	for name, c := range map[string]struct {
+		expireAt  int64
+		revokedAt int64
+		autoRenew string
+		want      []RenewalEventKind
+		tracked   bool
+	}{
+		"active":                     {active, 0, "1", nil, true},
+		"auto-renew off":             {active, 0, "0", []RenewalEventKind{RenewalCancelled}, true},
+		"auto-renew off and expired": {expired, 0, "0", []RenewalEventKind{RenewalCancelled, RenewalExpired}, false},
+		"expired":                    {expired, 0, "1", []RenewalEventKind{RenewalExpired}, false},
+		"revoked":                    {active, now.Unix(), "1", []RenewalEventKind{RenewalCancelled}, false},
+		"revoked after expiry":       {expired, now.Unix(), "1", []RenewalEventKind{RenewalCancelled}, false},
+	} {
+		var body = fmt.Sprintf(`{"status":0,"latest_receipt_info":[{"product_id":"monthly","transaction_id":"1",
+			"original_transaction_id":"1","expires_date_ms":"%d","cancellation_date_ms":"%d"}],
+			"pending_renewal_info":[{"original_transaction_id":"1","auto_renew_status":"%s"}]}`,
+			c.expireAt*1000, c.revokedAt*1000, c.autoRenew)
+
+		var (
+			ctx   = context.Background()
+			store = NewMemoryScheduleStore()
+			got   []RenewalEventKind
+			s     = &Scheduler{
+				Client: &Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+					return jsonResponse(200, body), nil
+				})}},
+				Store: store,
+				OnEvent: func(_ context.Context, e RenewalEvent) error {
+					got = append(got, e.Kind)
+					return nil
+				},
+			}
+		)
+
+		var check = ScheduledCheck{OriginalID: "1", Receipt: "receipt", ExpireAt: c.expireAt, AccessExpireAt: c.expireAt, AutoRenew: true}
+
+		if err := s.check(ctx, check, now); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: events %v, want %v", name, got, c.want)
+		}
+
+		if _, tracked := store.checks["1"]; tracked != c.tracked {
+			t.Errorf("%s: tracked %v, want %v", name, tracked, c.tracked)
+		}
+	}
+}
+
+func TestSchedulerFailedChecks(t *testing.T) {
+	for name, c := range map[string]struct {
+		code    int
+		body    string
+		want    int
+		tracked bool
+	}{
+		"temporary status": {200, `{"status":21005}`, 10, true},
+		"unavailable":      {503, `<html>Service Unavailable</html>`, 10, true},
+		"rejected receipt": {200, `{"status":21010}`, 1, false},
+		"wrong secret":     {200, `{"status":21004}`, 3, false},
+		"malformed":        {200, `<html>OK</html>`, 3, false},
+	} {
+		var (
+			ctx      = context.Background()
+			store    = NewMemoryScheduleStore()
+			requests int
+			s        = &Scheduler{
+				Client: &Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+					requests++
+					return jsonResponse(c.code, c.body), nil
+				})}},
+				Store:       store,
+				MaxAttempts: 3,
+			}
+		)
+
+		if err := store.Put(ctx, ScheduledCheck{OriginalID: "1", Receipt: "receipt"}); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 10; i++ {
+			if _, err := s.RunOnce(ctx, time.Now().Add(time.Duration(i)*s.retryInterval())); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		if _, tracked := store.checks["1"]; requests != c.want || tracked != c.tracked {
+			t.Errorf("%s: %d requests, tracked %v, want %d requests, tracked %v", name, requests, tracked, c.want, c.tracked)
+		}
+	}
+}
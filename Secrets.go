@@ -0,0 +1,162 @@
+package AppleTransactions
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// statusSharedSecretMismatch - verifyReceipt status of wrong shared secret.
+const statusSharedSecretMismatch = 21004
+
+// Secret - version of an app-specific shared secret.
+type Secret struct {
+	// Version - label for logs, like "2024-05" or a vault revision.
+	Version string
+	Value   string
+
+	// RotatedAt - when it replaced the previous secret.
+	RotatedAt time.Time
+}
+
+// SecretProvider - resolves shared secrets of apps, implementations must be safe for concurrent use.
+type SecretProvider interface {
+	// Secrets - current secret of bundleID first, then older ones still worth trying, empty if app is unknown.
+	Secrets(ctx context.Context, bundleID string) ([]Secret, error)
+}
+
+// Logger - *log.Logger fits.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// verifyWithSecrets - verifyReceipt with secrets of receipt app, next secret is tried while Apple answers 21004.
+func (c *Client) verifyWithSecrets(ctx context.Context, receipt string) (resp receiptData, err error) {
+	bundleID, err := c.receiptBundleID(ctx, receipt)
+	if err != nil {
+		return resp, err
+	}
+
+	secrets, err := c.Secrets.Secrets(ctx, bundleID)
+	if err != nil {
+		return resp, errors.Wrap(err, "secrets Secrets")
+	}
+
+	if len(secrets) == 0 {
+		return resp, errors.Errorf("no shared secret for bundle %q", bundleID)
+	}
+
+	for _, s := range secrets {
+		if resp, err = c.verify(ctx, receipt, s.Value); err != nil {
+			return resp, err
+		}
+
+		if resp.Status != statusSharedSecretMismatch {
+			if resp.Status == 0 {
+				c.logf("apple receipt of %s validated with shared secret version %s", bundleID, s.Version)
+			}
+
+			return resp, nil
+		}
+
+		c.logf("apple rejected shared secret version %s of %s", s.Version, bundleID)
+	}
+
+	return resp, nil
+}
+
+// receiptBundleID - bundle id from offline parse, or from verifyReceipt without secret if receipt can't be parsed.
+func (c *Client) receiptBundleID(ctx context.Context, receipt string) (string, error) {
+	if p, err := ParseReceipt(receipt); err == nil && p.BundleID != "" {
+		return p.BundleID, nil
+	}
+
+	resp, err := c.verify(ctx, receipt, "")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Receipt.BundleID == "" {
+		return "", errors.Wrap(StatusError(resp.Status), "can't resolve bundle id of receipt")
+	}
+
+	return resp.Receipt.BundleID, nil
+}
+
+// MemorySecretProvider - SecretProvider of secrets set in process.
+//
+// Previous secret of an app is still tried for Window after rotation,
+// secrets of bundle id "" are used for apps without their own.
+type MemorySecretProvider struct {
+	// Window - how long previous secret stays valid after rotation, previous secret isn't tried if 0.
+	Window time.Duration
+
+	// Now - time.Now if nil.
+	Now func() time.Time
+
+	mu      sync.RWMutex
+	secrets map[string][]Secret
+}
+
+// NewMemorySecretProvider - empty MemorySecretProvider with rotation window.
+func NewMemorySecretProvider(window time.Duration) *MemorySecretProvider {
+	return &MemorySecretProvider{Window: window, secrets: make(map[string][]Secret)}
+}
+
+// Rotate - makes s current secret of bundleID, RotatedAt is set to now if zero.
+func (p *MemorySecretProvider) Rotate(bundleID string, s Secret) {
+	if s.RotatedAt.IsZero() {
+		s.RotatedAt = p.now()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.secrets == nil {
+		p.secrets = make(map[string][]Secret)
+	}
+
+	var versions = p.secrets[bundleID]
+	if len(versions) > 1 {
+		versions = versions[:1]
+	}
+
+	p.secrets[bundleID] = append([]Secret{s}, versions...)
+}
+
+func (p *MemorySecretProvider) Secrets(_ context.Context, bundleID string) ([]Secret, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	versions, ok := p.secrets[bundleID]
+	if !ok {
+		versions = p.secrets[""]
+	}
+
+	if len(versions) == 0 {
+		return nil, nil
+	}
+
+	var res = []Secret{versions[0]}
+
+	if len(versions) > 1 && p.now().Before(versions[0].RotatedAt.Add(p.Window)) {
+		res = append(res, versions[1])
+	}
+
+	return res, nil
+}
+
+func (p *MemorySecretProvider) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,393 @@
+package AppleTransactions
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://developer.apple.com/documentation/appstoreserverapi/generating_json_web_tokens_for_api_requests
+
+// SigningKey - in-app purchase key from App Store Connect, signs App Store Server API tokens.
+type SigningKey struct {
+	IssuerID string
+	KeyID    string
+	Key      *ecdsa.PrivateKey
+}
+
+// KeyProvider - resolves signing keys, implementations must be safe for concurrent use.
+//
+// Implement it for Vault-like stores, wrap in CachedKeyProvider if lookups are slow.
+type KeyProvider interface {
+	// Keys - keys of issuerID, preferred first, empty if issuer is unknown.
+	// Preferred key is the one rotated in last, new tokens and offers are signed with it.
+	Keys(ctx context.Context, issuerID string) ([]SigningKey, error)
+}
+
+// SigningKeyByID - key keyID of issuerID, preferred key if keyID is empty.
+func SigningKeyByID(ctx context.Context, p KeyProvider, issuerID, keyID string) (res SigningKey, err error) {
+	keys, err := p.Keys(ctx, issuerID)
+	if err != nil {
+		return res, errors.Wrap(err, "keys Keys")
+	}
+
+	for _, k := range keys {
+		if keyID == "" || k.KeyID == keyID {
+			return k, nil
+		}
+	}
+
+	return res, errors.Errorf("no signing key %q of issuer %q", keyID, issuerID)
+}
+
+// maxBearerTokenTTL - Apple rejects tokens valid for longer than an hour.
+const maxBearerTokenTTL = time.Hour
+
+// BearerToken - App Store Server API token of bundleID valid for ttl, capped at an hour.
+func (k SigningKey) BearerToken(bundleID string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > maxBearerTokenTTL {
+		ttl = maxBearerTokenTTL
+	}
+
+	var now = time.Now()
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": k.KeyID, "typ": "JWT"})
+	if err != nil {
+		return "", errors.Wrap(err, "failed Marshal header")
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": k.IssuerID,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"aud": "appstoreconnect-v1",
+		"bid": bundleID,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed Marshal claims")
+	}
+
+	var signing = base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	var digest = sha256.Sum256([]byte(signing))
+
+	r, s, err := ecdsa.Sign(rand.Reader, k.Key, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed ecdsa.Sign")
+	}
+
+	// JWS signature is r and s as fixed size big endian numbers
+	var sig = make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseKeyMaterial - .p8 PEM, its base64 or base64 PKCS#8 DER, as keys are kept in env and secret stores.
+func parseKeyMaterial(b []byte) (*ecdsa.PrivateKey, error) {
+	// .env files keep newlines escaped
+	b = bytes.TrimSpace(bytes.ReplaceAll(b, []byte(`\n`), []byte("\n")))
+
+	if !bytes.HasPrefix(b, []byte("-----")) {
+		raw, err := base64.StdEncoding.DecodeString(string(b))
+		if err != nil {
+			return nil, errors.New("key is neither pem nor base64")
+		}
+
+		if !bytes.HasPrefix(raw, []byte("-----")) {
+			raw = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: raw})
+		}
+
+		b = raw
+	}
+
+	return ParsePrivateKey(b)
+}
+
+// FileKeyProvider - keys of one issuer from AuthKey_<KeyID>.p8 files in Dir, as App Store Connect names them.
+//
+// Dir is rescanned every CheckEvery, so rotated keys are picked up without restart,
+// newest file is preferred. Files which can't be read are skipped, keys of other files stay usable.
+type FileKeyProvider struct {
+	IssuerID string
+	Dir      string
+
+	// CheckEvery - 1 minute if 0.
+	CheckEvery time.Duration
+
+	// Logger - may be nil, gets key files skipped on reload.
+	Logger Logger
+
+	mu      sync.Mutex
+	checked time.Time
+	files   map[string]fileKey
+}
+
+type fileKey struct {
+	modTime time.Time
+	key     SigningKey
+}
+
+func (p *FileKeyProvider) Keys(_ context.Context, issuerID string) ([]SigningKey, error) {
+	if issuerID != p.IssuerID {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var every = p.CheckEvery
+	if every <= 0 {
+		every = time.Minute
+	}
+
+	if time.Since(p.checked) >= every {
+		// keys loaded before stay in use if Dir is broken mid rotation
+		if err := p.reload(); err != nil && len(p.files) == 0 {
+			return nil, err
+		}
+
+		p.checked = time.Now()
+	}
+
+	var files = make([]fileKey, 0, len(p.files))
+	for _, f := range p.files {
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var res = make([]SigningKey, len(files))
+	for i, f := range files {
+		res[i] = f.key
+	}
+
+	return res, nil
+}
+
+// reload - rereads changed key files, p.mu must be held.
+//
+// Broken files are logged and skipped, their previous version stays in use, returns the first failure.
+func (p *FileKeyProvider) reload() (err error) {
+	paths, err := filepath.Glob(filepath.Join(p.Dir, "AuthKey_*.p8"))
+	if err != nil {
+		return errors.Wrap(err, "failed Glob")
+	}
+
+	var files = make(map[string]fileKey, len(paths))
+
+	for _, path := range paths {
+		f, fileErr := p.load(path)
+		if fileErr != nil {
+			fileErr = errors.Wrapf(fileErr, "key file %s", filepath.Base(path))
+			p.logf("skipped %v", fileErr)
+
+			if err == nil {
+				err = fileErr
+			}
+
+			if prev, ok := p.files[path]; ok {
+				files[path] = prev
+			}
+
+			continue
+		}
+
+		files[path] = f
+	}
+
+	p.files = files
+
+	return err
+}
+
+// load - key of path, reused if file didn't change since the last reload.
+func (p *FileKeyProvider) load(path string) (res fileKey, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return res, errors.Wrap(err, "failed Stat")
+	}
+
+	if f, ok := p.files[path]; ok && f.modTime.Equal(info.ModTime()) {
+		return f, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return res, errors.Wrap(err, "failed ReadFile")
+	}
+
+	key, err := parseKeyMaterial(raw)
+	if err != nil {
+		return res, errors.Wrap(err, "failed parseKeyMaterial")
+	}
+
+	var keyID = strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "AuthKey_"), ".p8")
+
+	return fileKey{
+		modTime: info.ModTime(),
+		key:     SigningKey{IssuerID: p.IssuerID, KeyID: keyID, Key: key},
+	}, nil
+}
+
+func (p *FileKeyProvider) logf(format string, v ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, v...)
+	}
+}
+
+// EnvKeyProvider - keys of one issuer from environment variables Prefix<KeyID>.
+//
+// Values are .p8 contents, optionally base64 encoded, environment is read on every call.
+// Prefix + "CURRENT" names KeyID of the preferred key, it's required once there are several keys,
+// others follow in key id order. Variables which can't be parsed are skipped.
+type EnvKeyProvider struct {
+	IssuerID string
+
+	// Prefix - "APPLE_SIGNING_KEY_" if empty.
+	Prefix string
+
+	// Logger - may be nil, gets key variables skipped.
+	Logger Logger
+
+	mu     sync.Mutex
+	parsed map[string]*ecdsa.PrivateKey
+}
+
+func (p *EnvKeyProvider) Keys(_ context.Context, issuerID string) (res []SigningKey, err error) {
+	if issuerID != p.IssuerID {
+		return nil, nil
+	}
+
+	var prefix = p.Prefix
+	if prefix == "" {
+		prefix = "APPLE_SIGNING_KEY_"
+	}
+
+	var current = os.Getenv(prefix + "CURRENT")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		parsed  = make(map[string]*ecdsa.PrivateKey)
+		skipped error
+	)
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) || name == prefix+"CURRENT" || value == "" {
+			continue
+		}
+
+		key, ok := p.parsed[value]
+		if !ok {
+			if key, err = parseKeyMaterial([]byte(value)); err != nil {
+				err = errors.Wrapf(err, "key variable %s", name)
+				p.logf("skipped %v", err)
+
+				if skipped == nil {
+					skipped = err
+				}
+
+				continue
+			}
+		}
+
+		parsed[value] = key
+		res = append(res, SigningKey{IssuerID: p.IssuerID, KeyID: strings.TrimPrefix(name, prefix), Key: key})
+	}
+
+	p.parsed = parsed
+
+	if len(res) == 0 {
+		return nil, skipped
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if (res[i].KeyID == current) != (res[j].KeyID == current) {
+			return res[i].KeyID == current
+		}
+
+		return res[i].KeyID < res[j].KeyID
+	})
+
+	if len(res) > 1 && res[0].KeyID != current {
+		if current == "" {
+			return nil, errors.Errorf("%d keys and no %sCURRENT", len(res), prefix)
+		}
+
+		return nil, errors.Errorf("no usable key %s%s", prefix, current)
+	}
+
+	return res, nil
+}
+
+func (p *EnvKeyProvider) logf(format string, v ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, v...)
+	}
+}
+
+// CachedKeyProvider - caches keys of Provider for TTL, so rotated keys show up within TTL.
+//
+// Last keys keep being served while Provider fails, so an outage of the store doesn't stop signing.
+type CachedKeyProvider struct {
+	Provider KeyProvider
+
+	// TTL - 5 minutes if 0.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedKeys
+}
+
+type cachedKeys struct {
+	keys      []SigningKey
+	fetchedAt time.Time
+}
+
+func (p *CachedKeyProvider) Keys(ctx context.Context, issuerID string) ([]SigningKey, error) {
+	var ttl = p.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[issuerID]
+	if ok && time.Since(e.fetchedAt) < ttl {
+		return e.keys, nil
+	}
+
+	keys, err := p.Provider.Keys(ctx, issuerID)
+	if err != nil {
+		if ok {
+			return e.keys, nil
+		}
+
+		return nil, err
+	}
+
+	if p.entries == nil {
+		p.entries = make(map[string]cachedKeys)
+	}
+
+	p.entries[issuerID] = cachedKeys{keys: keys, fetchedAt: time.Now()}
+
+	return keys, nil
+}
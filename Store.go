@@ -0,0 +1,665 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoredReceipt - latest receipt of a user for an app.
+type StoredReceipt struct {
+	UserID      string
+	BundleID    string
+	Environment string
+	Receipt     string
+
+	// ValidatedAt - Unix timestamp.
+	ValidatedAt int64
+}
+
+// NotificationEvent - received notification, kept for audit and replays.
+type NotificationEvent struct {
+	UUID          string
+	Type          NotificationType
+	Subtype       NotificationSubtype
+	Environment   string
+	BundleID      string
+	OriginalID    string
+	TransactionID string
+
+	SignedDateMS int64
+	// ReceivedAt - Unix timestamp.
+	ReceivedAt int64
+
+	// Stale - notification carried state older than already applied one, its transaction wasn't stored.
+	Stale bool
+}
+
+// Store - persists transactions and subscription state, implementations must be safe for concurrent use.
+//
+// Upserts replace earlier state with the same key, transaction saved with empty userID
+// keeps user already known for it or for its original transaction.
+type Store interface {
+	UpsertTransactions(ctx context.Context, userID string, transactions []Transaction) error
+	UpsertRenewalInfo(ctx context.Context, info RenewalInfo) error
+	UpsertReceipt(ctx context.Context, r StoredReceipt) error
+	// UpsertNotification - records e, duplicates of e.UUID are ignored.
+	UpsertNotification(ctx context.Context, e NotificationEvent) error
+
+	TransactionsByUser(ctx context.Context, userID string) ([]Transaction, error)
+	TransactionsByOriginalID(ctx context.Context, originalID string) ([]Transaction, error)
+	TransactionsByProduct(ctx context.Context, productID string) ([]Transaction, error)
+
+	// RenewalInfo - nil if nothing is stored for originalID.
+	RenewalInfo(ctx context.Context, originalID string) (*RenewalInfo, error)
+	Receipts(ctx context.Context, userID string) ([]StoredReceipt, error)
+	Notifications(ctx context.Context, originalID string) ([]NotificationEvent, error)
+}
+
+// ForUser - ValidateReceipt stores receipt and transactions for userID when Client.Store is set.
+func ForUser(userID string) ReceiptOption {
+	return func(o *receiptOptions) {
+		o.userID = userID
+	}
+}
+
+// storeError - failure of Client.Store after Apple validated the receipt, validating again doesn't fix it.
+type storeError struct {
+	error
+}
+
+func (e storeError) Unwrap() error {
+	return e.error
+}
+
+// write - stores validated receipt, transactions and renewal info.
+func (c *Client) write(ctx context.Context, userID, receipt string, res ReceiptResult) error {
+	if userID != "" {
+		if res.LatestReceipt != "" {
+			receipt = res.LatestReceipt
+		}
+
+		var r = StoredReceipt{
+			UserID:      userID,
+			BundleID:    res.BundleID,
+			Environment: res.Environment,
+			Receipt:     receipt,
+			ValidatedAt: time.Now().Unix(),
+		}
+
+		if err := c.Store.UpsertReceipt(ctx, r); err != nil {
+			return errors.Wrap(err, "store UpsertReceipt")
+		}
+	}
+
+	if err := c.Store.UpsertTransactions(ctx, userID, res.Transactions); err != nil {
+		return errors.Wrap(err, "store UpsertTransactions")
+	}
+
+	for _, info := range res.RenewalInfo {
+		if err := c.Store.UpsertRenewalInfo(ctx, info); err != nil {
+			return errors.Wrap(err, "store UpsertRenewalInfo")
+		}
+	}
+
+	return nil
+}
+
+// apply - writes n through to Store, then dispatches it.
+func (h *NotificationHandler) apply(ctx context.Context, n *Notification) error {
+	if h.Store != nil {
+		if err := h.write(ctx, n, false); err != nil {
+			return err
+		}
+	}
+
+	return h.Dispatch(ctx, n)
+}
+
+// write - records n, its transaction and renewal info are stored only if it isn't stale.
+func (h *NotificationHandler) write(ctx context.Context, n *Notification, stale bool) error {
+	var e = NotificationEvent{
+		UUID:         n.UUID,
+		Type:         n.Type,
+		Subtype:      n.Subtype,
+		Environment:  n.Environment,
+		BundleID:     n.BundleID,
+		OriginalID:   n.orderKey(),
+		SignedDateMS: n.SignedDateMS,
+		ReceivedAt:   time.Now().Unix(),
+		Stale:        stale,
+	}
+
+	if n.Transaction != nil {
+		e.TransactionID = n.Transaction.ID
+	}
+
+	if e.UUID == "" {
+		// V1 notifications have no UUID
+		var err error
+		if e.UUID, err = newUUID(); err != nil {
+			return err
+		}
+	}
+
+	if err := h.Store.UpsertNotification(ctx, e); err != nil {
+		return errors.Wrap(err, "store UpsertNotification")
+	}
+
+	if stale {
+		return nil
+	}
+
+	if n.Transaction != nil {
+		if err := h.Store.UpsertTransactions(ctx, "", []Transaction{*n.Transaction}); err != nil {
+			return errors.Wrap(err, "store UpsertTransactions")
+		}
+	}
+
+	if n.RenewalInfo != nil {
+		if err := h.Store.UpsertRenewalInfo(ctx, *n.RenewalInfo); err != nil {
+			return errors.Wrap(err, "store UpsertRenewalInfo")
+		}
+	}
+
+	return nil
+}
+
+// MemoryStore - in-process Store, forgets everything on restart.
+type MemoryStore struct {
+	mu            sync.Mutex
+	transactions  map[string]storedTransaction
+	renewals      map[string]RenewalInfo
+	receipts      map[[2]string]StoredReceipt
+	notifications map[string]NotificationEvent
+}
+
+type storedTransaction struct {
+	Transaction
+	userID string
+}
+
+// NewMemoryStore - empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		transactions:  make(map[string]storedTransaction),
+		renewals:      make(map[string]RenewalInfo),
+		receipts:      make(map[[2]string]StoredReceipt),
+		notifications: make(map[string]NotificationEvent),
+	}
+}
+
+func (s *MemoryStore) UpsertTransactions(_ context.Context, userID string, transactions []Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range transactions {
+		var user = userID
+
+		if user == "" {
+			user = s.transactions[t.ID].userID
+		}
+
+		if user == "" {
+			for _, v := range s.transactions {
+				if v.OriginalID == t.OriginalID && v.userID != "" {
+					user = v.userID
+					break
+				}
+			}
+		}
+
+		s.transactions[t.ID] = storedTransaction{Transaction: t, userID: user}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) UpsertRenewalInfo(_ context.Context, info RenewalInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.renewals[info.OriginalTransactionID] = info
+
+	return nil
+}
+
+func (s *MemoryStore) UpsertReceipt(_ context.Context, r StoredReceipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.receipts[[2]string{r.UserID, r.BundleID}] = r
+
+	return nil
+}
+
+func (s *MemoryStore) UpsertNotification(_ context.Context, e NotificationEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.notifications[e.UUID]; !ok {
+		s.notifications[e.UUID] = e
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) TransactionsByUser(_ context.Context, userID string) ([]Transaction, error) {
+	return s.transactionsBy(func(t storedTransaction) bool { return t.userID == userID }), nil
+}
+
+func (s *MemoryStore) TransactionsByOriginalID(_ context.Context, originalID string) ([]Transaction, error) {
+	return s.transactionsBy(func(t storedTransaction) bool { return t.OriginalID == originalID }), nil
+}
+
+func (s *MemoryStore) TransactionsByProduct(_ context.Context, productID string) ([]Transaction, error) {
+	return s.transactionsBy(func(t storedTransaction) bool { return t.InAppName == productID }), nil
+}
+
+// transactionsBy - matching transactions ordered by purchase date.
+func (s *MemoryStore) transactionsBy(match func(t storedTransaction) bool) (res []Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.transactions {
+		if match(t) {
+			res = append(res, t.Transaction)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].PurchasedAt != res[j].PurchasedAt {
+			return res[i].PurchasedAt < res[j].PurchasedAt
+		}
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
+
+func (s *MemoryStore) RenewalInfo(_ context.Context, originalID string) (*RenewalInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.renewals[originalID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &info, nil
+}
+
+func (s *MemoryStore) Receipts(_ context.Context, userID string) (res []StoredReceipt, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.receipts {
+		if r.UserID == userID {
+			res = append(res, r)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].BundleID < res[j].BundleID })
+
+	return res, nil
+}
+
+func (s *MemoryStore) Notifications(_ context.Context, originalID string) (res []NotificationEvent, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.notifications {
+		if e.OriginalID == originalID {
+			res = append(res, e)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].SignedDateMS < res[j].SignedDateMS })
+
+	return res, nil
+}
+
+// SQLStore - Store on database/sql, works with SQLite and Postgres.
+type SQLStore struct {
+	DB *sql.DB
+}
+
+// NewSQLStore - SQLStore on db, call Migrate before use.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{DB: db}
+}
+
+// storeMigrations - schema versions of SQLStore, append only, applied ones must never change.
+var storeMigrations = [][]string{
+	1: {
+		`CREATE TABLE apple_transactions (
+			transaction_id TEXT PRIMARY KEY,
+			original_transaction_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			quantity INTEGER NOT NULL,
+			subscription_expire_at BIGINT NOT NULL,
+			access_expire_at BIGINT NOT NULL,
+			purchased_at BIGINT NOT NULL,
+			subscription_group TEXT NOT NULL,
+			is_trial_period BOOLEAN NOT NULL,
+			is_in_intro_offer_period BOOLEAN NOT NULL,
+			is_upgraded BOOLEAN NOT NULL,
+			ownership_type TEXT NOT NULL,
+			revoked_at BIGINT NOT NULL,
+			product_type TEXT NOT NULL
+		)`,
+		`CREATE INDEX apple_transactions_original_transaction_id ON apple_transactions (original_transaction_id)`,
+		`CREATE INDEX apple_transactions_user_id ON apple_transactions (user_id)`,
+		`CREATE INDEX apple_transactions_product_id ON apple_transactions (product_id)`,
+		`CREATE TABLE apple_renewal_info (
+			original_transaction_id TEXT PRIMARY KEY,
+			product_id TEXT NOT NULL,
+			auto_renew_product_id TEXT NOT NULL,
+			auto_renew_status BOOLEAN NOT NULL,
+			expiration_intent INTEGER NOT NULL,
+			is_in_billing_retry_period BOOLEAN NOT NULL,
+			grace_period_expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE apple_receipts (
+			user_id TEXT NOT NULL,
+			bundle_id TEXT NOT NULL,
+			environment TEXT NOT NULL,
+			receipt TEXT NOT NULL,
+			validated_at BIGINT NOT NULL,
+			PRIMARY KEY (user_id, bundle_id)
+		)`,
+		`CREATE TABLE apple_notifications (
+			uuid TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			subtype TEXT NOT NULL,
+			environment TEXT NOT NULL,
+			bundle_id TEXT NOT NULL,
+			original_transaction_id TEXT NOT NULL,
+			transaction_id TEXT NOT NULL,
+			signed_date BIGINT NOT NULL,
+			received_at BIGINT NOT NULL,
+			stale BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX apple_notifications_original_transaction_id ON apple_notifications (original_transaction_id)`,
+	},
+	2: {
+		`ALTER TABLE apple_transactions ADD COLUMN app_account_token TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// Migrate - applies schema versions missing in apple_schema_migrations, each in its own transaction.
+//
+// Safe to run from several processes at once, version row is inserted first and locks the version.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS apple_schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed create table")
+	}
+
+	for version := 1; version < len(storeMigrations); version++ {
+		if err = s.migrate(ctx, version, storeMigrations[version]); err != nil {
+			return errors.Wrapf(err, "migration %d", version)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) migrate(ctx context.Context, version int, statements []string) (err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed BeginTx")
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO apple_schema_migrations (version, applied_at) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`,
+		version, time.Now().Unix())
+	if err != nil {
+		return errors.Wrap(err, "failed insert version")
+	}
+
+	fresh, err := affected(res)
+	if err != nil {
+		return err
+	}
+
+	if !fresh {
+		return tx.Rollback()
+	}
+
+	for _, q := range statements {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "failed exec")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "failed Commit")
+}
+
+// transactionColumns - apple_transactions columns in order of scanTransactions.
+var transactionColumns = []string{
+	"transaction_id", "original_transaction_id", "product_id", "quantity",
+	"subscription_expire_at", "access_expire_at", "purchased_at", "subscription_group",
+	"is_trial_period", "is_in_intro_offer_period", "is_upgraded", "ownership_type",
+	"revoked_at", "product_type", "app_account_token",
+}
+
+func (s *SQLStore) UpsertTransactions(ctx context.Context, userID string, transactions []Transaction) (err error) {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	var (
+		columns = strings.Join(transactionColumns, ", ")
+		updates = make([]string, len(transactionColumns))
+	)
+
+	for i, c := range transactionColumns {
+		updates[i] = c + " = excluded." + c
+	}
+
+	// empty user keeps known user of the transaction or of its original transaction,
+	// placeholders go in order of first use, as SQLite drivers bind them by position
+	var q = `INSERT INTO apple_transactions (` + columns + `, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+		COALESCE(NULLIF($16, ''), (SELECT MAX(user_id) FROM apple_transactions WHERE original_transaction_id = $2), ''))
+		ON CONFLICT (transaction_id) DO UPDATE SET ` + strings.Join(updates, ", ") + `,
+		user_id = CASE WHEN excluded.user_id = '' THEN apple_transactions.user_id ELSE excluded.user_id END`
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed BeginTx")
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, t := range transactions {
+		_, err = tx.ExecContext(ctx, q,
+			t.ID, t.OriginalID, t.InAppName, t.Quantity,
+			t.SubscriptionExpireAt, t.AccessExpireAt, t.PurchasedAt, t.SubscriptionGroup,
+			t.IsTrialPeriod, t.IsInIntroOfferPeriod, t.IsUpgraded, string(t.OwnershipType),
+			t.RevokedAt, string(t.ProductType), t.AppAccountToken, userID)
+		if err != nil {
+			return errors.Wrap(err, "failed upsert transaction")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "failed Commit")
+}
+
+func (s *SQLStore) UpsertRenewalInfo(ctx context.Context, info RenewalInfo) error {
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_renewal_info (original_transaction_id, product_id, auto_renew_product_id,
+		auto_renew_status, expiration_intent, is_in_billing_retry_period, grace_period_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (original_transaction_id) DO UPDATE SET
+		product_id = excluded.product_id, auto_renew_product_id = excluded.auto_renew_product_id,
+		auto_renew_status = excluded.auto_renew_status, expiration_intent = excluded.expiration_intent,
+		is_in_billing_retry_period = excluded.is_in_billing_retry_period,
+		grace_period_expires_at = excluded.grace_period_expires_at`,
+		info.OriginalTransactionID, info.ProductID, info.AutoRenewProductID,
+		info.AutoRenewStatus, info.ExpirationIntent, info.IsInBillingRetryPeriod, info.GracePeriodExpiresAt)
+
+	return errors.Wrap(err, "failed upsert renewal info")
+}
+
+func (s *SQLStore) UpsertReceipt(ctx context.Context, r StoredReceipt) error {
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_receipts (user_id, bundle_id, environment, receipt, validated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, bundle_id) DO UPDATE SET
+		environment = excluded.environment, receipt = excluded.receipt, validated_at = excluded.validated_at`,
+		r.UserID, r.BundleID, r.Environment, r.Receipt, r.ValidatedAt)
+
+	return errors.Wrap(err, "failed upsert receipt")
+}
+
+func (s *SQLStore) UpsertNotification(ctx context.Context, e NotificationEvent) error {
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO apple_notifications (uuid, type, subtype, environment, bundle_id,
+		original_transaction_id, transaction_id, signed_date, received_at, stale)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (uuid) DO NOTHING`,
+		e.UUID, string(e.Type), string(e.Subtype), e.Environment, e.BundleID,
+		e.OriginalID, e.TransactionID, e.SignedDateMS, e.ReceivedAt, e.Stale)
+
+	return errors.Wrap(err, "failed insert notification")
+}
+
+func (s *SQLStore) TransactionsByUser(ctx context.Context, userID string) ([]Transaction, error) {
+	return s.transactionsBy(ctx, "user_id", userID)
+}
+
+func (s *SQLStore) TransactionsByOriginalID(ctx context.Context, originalID string) ([]Transaction, error) {
+	return s.transactionsBy(ctx, "original_transaction_id", originalID)
+}
+
+func (s *SQLStore) TransactionsByProduct(ctx context.Context, productID string) ([]Transaction, error) {
+	return s.transactionsBy(ctx, "product_id", productID)
+}
+
+// transactionsBy - transactions with column equal to value ordered by purchase date, column is never user input.
+func (s *SQLStore) transactionsBy(ctx context.Context, column, value string) (res []Transaction, err error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+strings.Join(transactionColumns, ", ")+` FROM apple_transactions
+		WHERE `+column+` = $1 ORDER BY purchased_at, transaction_id`,
+		value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select transactions")
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			t         Transaction
+			ownership string
+			product   string
+		)
+
+		err = rows.Scan(&t.ID, &t.OriginalID, &t.InAppName, &t.Quantity,
+			&t.SubscriptionExpireAt, &t.AccessExpireAt, &t.PurchasedAt, &t.SubscriptionGroup,
+			&t.IsTrialPeriod, &t.IsInIntroOfferPeriod, &t.IsUpgraded, &ownership,
+			&t.RevokedAt, &product, &t.AppAccountToken)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed Scan")
+		}
+
+		t.OwnershipType, t.ProductType = OwnershipType(ownership), ProductType(product)
+		res = append(res, t)
+	}
+
+	return res, errors.Wrap(rows.Err(), "failed rows")
+}
+
+func (s *SQLStore) RenewalInfo(ctx context.Context, originalID string) (*RenewalInfo, error) {
+	var info RenewalInfo
+
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT original_transaction_id, product_id, auto_renew_product_id, auto_renew_status,
+		expiration_intent, is_in_billing_retry_period, grace_period_expires_at
+		FROM apple_renewal_info WHERE original_transaction_id = $1`,
+		originalID).Scan(&info.OriginalTransactionID, &info.ProductID, &info.AutoRenewProductID, &info.AutoRenewStatus,
+		&info.ExpirationIntent, &info.IsInBillingRetryPeriod, &info.GracePeriodExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select renewal info")
+	}
+
+	return &info, nil
+}
+
+func (s *SQLStore) Receipts(ctx context.Context, userID string) (res []StoredReceipt, err error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT user_id, bundle_id, environment, receipt, validated_at FROM apple_receipts
+		WHERE user_id = $1 ORDER BY bundle_id`,
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select receipts")
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var r StoredReceipt
+
+		if err = rows.Scan(&r.UserID, &r.BundleID, &r.Environment, &r.Receipt, &r.ValidatedAt); err != nil {
+			return nil, errors.Wrap(err, "failed Scan")
+		}
+
+		res = append(res, r)
+	}
+
+	return res, errors.Wrap(rows.Err(), "failed rows")
+}
+
+func (s *SQLStore) Notifications(ctx context.Context, originalID string) (res []NotificationEvent, err error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT uuid, type, subtype, environment, bundle_id, original_transaction_id, transaction_id,
+		signed_date, received_at, stale FROM apple_notifications
+		WHERE original_transaction_id = $1 ORDER BY signed_date`,
+		originalID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed select notifications")
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			e                     NotificationEvent
+			notification, subtype string
+		)
+
+		err = rows.Scan(&e.UUID, &notification, &subtype, &e.Environment, &e.BundleID, &e.OriginalID, &e.TransactionID,
+			&e.SignedDateMS, &e.ReceivedAt, &e.Stale)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed Scan")
+		}
+
+		e.Type, e.Subtype = NotificationType(notification), NotificationSubtype(subtype)
+		res = append(res, e)
+	}
+
+	return res, errors.Wrap(rows.Err(), "failed rows")
+}
@@ -0,0 +1,34 @@
+package AppleTransactions
+
+import (
+	"github.com/pkg/errors"
+)
+
+// TransactionsByJWS - verifies Transaction.jwsRepresentation values sent by StoreKit 2 client.
+//
+// Result has the same shape TransactionsByReceipt returns, with unique transaction IDs.
+// Transactions of apps or environments other than BundleID and Environment fail the call.
+func (v *JWSVerifier) TransactionsByJWS(signed ...string) (res []Transaction, err error) {
+	var unique = make(map[string]struct{}, len(signed))
+
+	for i, s := range signed {
+		var tx signedTransaction
+
+		if err = v.Verify(s, &tx); err != nil {
+			return nil, errors.Wrapf(err, "verify transaction %d", i)
+		}
+
+		if err = v.checkApp(tx.BundleID, tx.Environment); err != nil {
+			return nil, errors.Wrapf(err, "transaction %d", i)
+		}
+
+		if _, ok := unique[tx.TransactionID]; ok {
+			continue
+		}
+
+		unique[tx.TransactionID] = struct{}{}
+		res = append(res, tx.transaction())
+	}
+
+	return res, nil
+}
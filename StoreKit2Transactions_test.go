@@ -0,0 +1,127 @@
+package AppleTransactions
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigner - signs JWS payloads like the App Store, with a generated chain carrying Apple marker extensions.
+type testSigner struct {
+	key *ecdsa.PrivateKey
+	x5c []string
+}
+
+// newTestJWS - verifier trusting a generated root and signer of payloads chaining to it.
+func newTestJWS(t *testing.T) (*JWSVerifier, *testSigner) {
+	t.Helper()
+
+	var (
+		now             = time.Now()
+		rootKey         = testECKey(t)
+		intermediateKey = testECKey(t)
+		s               = &testSigner{key: testECKey(t)}
+		root            = issue(t, "Test Root G3", rootKey, nil, nil, nil, true, now)
+		intermediate    = issue(t, "Test WWDR G6", intermediateKey, root, rootKey, oidAppleIntermediate, true, now)
+		leaf            = issue(t, "Test StoreKit Signing", s.key, intermediate, intermediateKey, oidAppleLeaf, false, now)
+		pool            = x509.NewCertPool()
+	)
+
+	pool.AddCert(root)
+
+	for _, c := range []*x509.Certificate{leaf, intermediate, root} {
+		s.x5c = append(s.x5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+
+	return &JWSVerifier{RootCertificates: pool}, s
+}
+
+func testECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func (s *testSigner) sign(t *testing.T, payload interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(jwsHeader{Alg: "ES256", X5C: s.x5c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signing = base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signing))
+
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sig = make([]byte, 64)
+	r.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestTransactionsByJWSApp(t *testing.T) {
+	v, s := newTestJWS(t)
+
+	var tx = signedTransaction{
+		TransactionID:         "2000000000000001",
+		OriginalTransactionID: "2000000000000001",
+		BundleID:              "com.example.app",
+		ProductID:             "com.example.app.coins",
+		PurchaseDate:          time.Now().UnixMilli(),
+		Quantity:              1,
+		Environment:           "Production",
+	}
+
+	var other = tx
+	other.TransactionID, other.BundleID = "2000000000000002", "com.example.other"
+
+	var sandbox = tx
+	sandbox.TransactionID, sandbox.Environment = "2000000000000003", "Sandbox"
+
+	res, err := v.TransactionsByJWS(s.sign(t, tx), s.sign(t, other), s.sign(t, sandbox))
+	if err != nil || len(res) != 3 {
+		t.Fatalf("without BundleID and Environment: got %d transactions, %v", len(res), err)
+	}
+
+	v.BundleID, v.Environment = "com.example.app", "Production"
+
+	if res, err = v.TransactionsByJWS(s.sign(t, tx)); err != nil || len(res) != 1 || res[0].ID != tx.TransactionID {
+		t.Fatalf("matching transaction: got %v, %v", res, err)
+	}
+
+	for name, c := range map[string]struct {
+		tx   signedTransaction
+		want string
+	}{
+		"other bundle":      {other, "unexpected bundleId"},
+		"other environment": {sandbox, "unexpected environment"},
+	} {
+		if _, err = v.TransactionsByJWS(s.sign(t, tx), s.sign(t, c.tx)); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package AppleTransactions
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite - in-memory SQLite, skips the test when built without cgo.
+//
+// go-sqlite3 binds $N placeholders by position of first use, so it catches queries Postgres would accept.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every connection gets its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		t.Skipf("sqlite unavailable: %v", err)
+	}
+
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func newTestSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+
+	var s = NewSQLStore(openSQLite(t))
+
+	// second run must be a no-op
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return s
+}
+
+func TestStoreUpsertTransactions(t *testing.T) {
+	for name, s := range map[string]Store{
+		"memory": NewMemoryStore(),
+		"sql":    newTestSQLStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx   = context.Background()
+				first = Transaction{
+					ID:                   "1000000000000001",
+					OriginalID:           "1000000000000001",
+					InAppName:            "com.example.monthly",
+					Quantity:             1,
+					SubscriptionExpireAt: 1700002592,
+					AccessExpireAt:       1700002592,
+					PurchasedAt:          1700000000,
+					SubscriptionGroup:    "21000001",
+					IsTrialPeriod:        true,
+					OwnershipType:        OwnershipFamilyShared,
+					ProductType:          ProductAutoRenewable,
+					AppAccountToken:      "6f1c2e3a-1111-4222-8333-944445555666",
+				}
+				renewal = Transaction{
+					ID:                   "1000000000000002",
+					OriginalID:           first.OriginalID,
+					InAppName:            first.InAppName,
+					Quantity:             1,
+					SubscriptionExpireAt: 1702594592,
+					AccessExpireAt:       1702594592,
+					PurchasedAt:          1700002592,
+					IsUpgraded:           true,
+					RevokedAt:            1700100000,
+				}
+			)
+
+			if err := s.UpsertTransactions(ctx, "user-1", []Transaction{first}); err != nil {
+				t.Fatal(err)
+			}
+
+			// renewal of known original transaction is stored for its user
+			if err := s.UpsertTransactions(ctx, "", []Transaction{renewal}); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := s.TransactionsByUser(ctx, "user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(res, []Transaction{first, renewal}) {
+				t.Fatalf("got %+v", res)
+			}
+
+			// update without user keeps it, with another user moves the transaction
+			first.Quantity = 2
+
+			if err = s.UpsertTransactions(ctx, "", []Transaction{first}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.UpsertTransactions(ctx, "user-2", []Transaction{renewal}); err != nil {
+				t.Fatal(err)
+			}
+
+			for user, want := range map[string][]Transaction{"user-1": {first}, "user-2": {renewal}} {
+				if res, err = s.TransactionsByUser(ctx, user); err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(res, want) {
+					t.Errorf("%s: got %+v", user, res)
+				}
+			}
+
+			if res, err = s.TransactionsByOriginalID(ctx, first.OriginalID); err != nil || len(res) != 2 {
+				t.Errorf("by original id: got %d, %v", len(res), err)
+			}
+		})
+	}
+}
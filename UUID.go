@@ -0,0 +1,39 @@
+package AppleTransactions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// parseUUID - canonical textual UUID (identifierForVendor, appAccountToken) to 16 bytes.
+func parseUUID(s string) (res [16]byte, err error) {
+	var hexed = strings.ReplaceAll(s, "-", "")
+
+	if len(hexed) != 32 || len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return res, errors.Errorf("malformed uuid %q", s)
+	}
+
+	if _, err = hex.Decode(res[:], []byte(hexed)); err != nil {
+		return res, errors.Wrap(err, "failed decode uuid")
+	}
+
+	return res, nil
+}
+
+// newUUID - random version 4 UUID in lowercase canonical form.
+func newUUID() (string, error) {
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "failed rand.Read")
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	var h = hex.EncodeToString(b[:])
+
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
@@ -2,4 +2,7 @@ module github.com/appio-go/AppleTransactions
 
 go 1.20
 
-require github.com/pkg/errors v0.9.1 // indirect
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/pkg/errors v0.9.1
+)